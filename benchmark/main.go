@@ -51,11 +51,28 @@ func hashSymbols(in []testSymbol) []riblt.HashedSymbol[testSymbol] {
 	return res
 }
 
+// parseDegreeSequence parses the degree sequence given on the command line,
+// which is harmonic:<alpha> or iblt:<cells>:<degree>.
+func parseDegreeSequence(s string) riblt.DegreeSequence {
+	var alpha, degree float64
+	var cells uint64
+	if n, _ := fmt.Sscanf(s, "harmonic:%g", &alpha); n == 1 {
+		return riblt.Harmonic(alpha)
+	}
+	if n, _ := fmt.Sscanf(s, "iblt:%d:%g", &cells, &degree); n == 2 {
+		return riblt.IBLTPrefix{Cells: cells, Degree: degree}
+	}
+	panic("unknown degree sequence " + s)
+}
+
 func main() {
 	diff := flag.Int("d", 0, "number of differences")
 	set := flag.Int("s", 0, "size of set")
 	test := flag.Int("n", 100, "number of tests")
+	estK := flag.Int("k", 0, "number of coded symbols to estimate the difference from, 0 to disable")
+	seqName := flag.String("seq", "harmonic:2", "degree sequence, harmonic:<alpha> or iblt:<cells>:<degree>")
 	flag.Parse()
+	seq := parseDegreeSequence(*seqName)
 	if *diff % 2 != 0 {
 		panic("diff not an even number")
 	}
@@ -64,6 +81,9 @@ func main() {
 	ncommon := *set - *diff/2
 
 	totalCw := 0
+	totalEst := 0.0
+	nEst := 0
+	nCovered := 0
 
 	var encDur, decDur time.Duration
 	for testIdx := 0; testIdx < *test; testIdx += 1 {
@@ -77,6 +97,8 @@ func main() {
 		{
 			enc := riblt.Encoder[testSymbol]{}
 			dec := riblt.Decoder[testSymbol]{}
+			enc.SetDegreeSequence(seq)
+			dec.SetDegreeSequence(seq)
 			for i := 0; i < nlocal; i++ {
 				dec.AddHashedSymbol(hashedDiff[i])
 			}
@@ -94,16 +116,41 @@ func main() {
 			totalCw += ncw
 		}
 
+		// estimate the difference from the first estK coded symbols,
+		// which may be fewer or more than it takes to decode
+		if *estK != 0 {
+			enc := riblt.Encoder[testSymbol]{}
+			dec := riblt.Decoder[testSymbol]{}
+			enc.SetDegreeSequence(seq)
+			dec.SetDegreeSequence(seq)
+			for i := 0; i < nlocal; i++ {
+				dec.AddHashedSymbol(hashedDiff[i])
+			}
+			for i := nlocal; i < nlocal+nremote; i++ {
+				enc.AddHashedSymbol(hashedDiff[i])
+			}
+			for i := 0; i < *estK; i++ {
+				dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+			}
+			e := dec.EstimateDifference()
+			totalEst += e.Size
+			nEst += 1
+			if e.Lower <= float64(*diff) && float64(*diff) <= e.Upper {
+				nCovered += 1
+			}
+		}
+
 		// benchmark encode
 		{
 			var codewords riblt.Sketch[testSymbol] 
 			codewords = make([]riblt.CodedSymbol[testSymbol], ncw)
+			sketch := codewords.WithDegreeSequence(seq)
 			start := time.Now()
 			for i := nlocal; i < nlocal+nremote; i++ {
-				codewords.AddSymbol(diffData[i])
+				sketch.AddSymbol(diffData[i])
 			}
 			for i := 0; i < ncommon; i++ {
-				codewords.AddSymbol(commonData[i])
+				sketch.AddSymbol(commonData[i])
 			}
 			dur := time.Now().Sub(start)
 			encDur += dur
@@ -113,6 +160,8 @@ func main() {
 		{
 			enc := riblt.Encoder[testSymbol]{}
 			dec := riblt.Decoder[testSymbol]{}
+			enc.SetDegreeSequence(seq)
+			dec.SetDegreeSequence(seq)
 			// first fill the decoder
 			for i := 0; i < nlocal; i++ {
 				dec.AddHashedSymbol(hashedDiff[i])
@@ -133,6 +182,9 @@ func main() {
 		}
 	}
 
+	if nEst != 0 {
+		fmt.Printf("estimate %.2f, within bounds %d/%d\n", totalEst/float64(nEst), nCovered, nEst)
+	}
 	fmt.Printf("coded symbols %.2f, overhead %.2f, enc %.2f diff/s, dec %.2f diff/s\n", float64(totalCw)/float64(*test), float64(totalCw)/float64(*test)/float64(*diff), float64(*diff)*float64(*test) / encDur.Seconds(), float64(*test)*float64(*diff)/decDur.Seconds())
 }
 
@@ -14,14 +14,14 @@ func testOverlap(rng *rand.Rand, t, k, n, preadd int) (int, int) {
 	e := ldpc.NewEncoder(experiments.TestKey, dist, t)
 	d := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
-	txset := make(map[ldpc.Transaction]struct{})
+	txset := make(map[string]struct{})
 
 	cnt := 0
 	for cnt < t {
 		tx := experiments.RandomTransaction()
 		if e.AddTransaction(tx) {
 			if cnt < (t-preadd) {
-				txset[*tx] = struct{}{}
+				txset[string(tx.Serialized())] = struct{}{}
 			} else {
 				d.AddTransaction(tx)
 			}
@@ -36,7 +36,7 @@ func testOverlap(rng *rand.Rand, t, k, n, preadd int) (int, int) {
 		cw := e.ProduceCodeword()
 		stub, newtx := d.AddCodeword(cw)
 		for _, tx := range newtx {
-			delete(txset, *tx.Transaction)
+			delete(txset, string(tx.Serialized()))
 		}
 		stoppingSet = append(stoppingSet, stub)
 		if len(stoppingSet) > n {
@@ -6,10 +6,10 @@ import (
 )
 
 func RandomTransaction() *ldpc.Transaction {
-	d := ldpc.TransactionData{}
-    rand.Read(d[:])
+	d := make([]byte, ldpc.TxSize)
+    rand.Read(d)
 	t := &ldpc.Transaction{}
-	t.UnmarshalBinary(d[:])
+	t.UnmarshalBinary(d)
     return t
 }
 
@@ -23,7 +23,7 @@ func testController(K int, overlap, rinit float64, timeout int) {
 
 	d := &decoder{ldpc.NewDecoder(experiments.TestKey, 262144), []receivedCodeword{}}
 	e := ldpc.NewEncoder(experiments.TestKey, dist, K)
-	txset := make(map[ldpc.Transaction]struct{})
+	txset := make(map[string]struct{})
 
 	// one step is 1ms
 	step := 0
@@ -47,14 +47,14 @@ func testController(K int, overlap, rinit float64, timeout int) {
 		stub, txs := d.AddCodeword(cw)
 		d.rxWindow = append(d.rxWindow, receivedCodeword{stub, step})
 		for _, t := range txs {
-			delete(txset, *t)
+			delete(txset, string(t.Serialized()))
 		}
 		return
 	}
 	addTx := func(d *decoder, tx *ldpc.Transaction) {
 		txs := d.AddTransaction(tx)
 		for _, t := range txs {
-			delete(txset, *t)
+			delete(txset, string(t.Serialized()))
 		}
 		return
 	}
@@ -73,7 +73,7 @@ func testController(K int, overlap, rinit float64, timeout int) {
 		} else {
 			e.AddTransaction(tx)
 			if step > start {
-				txset[*tx] = struct{}{}
+				txset[string(tx.Serialized())] = struct{}{}
 				added += 1
 			}
 		}
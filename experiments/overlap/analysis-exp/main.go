@@ -16,16 +16,16 @@ func testOverlap(T1, T2, Tc, K int) (c1, c2 int) {
 	e2 := ldpc.NewEncoder(experiments.TestKey, dist2, T2+Tc)
 	d := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
-	txset1 := make(map[ldpc.Transaction]struct{})
-	txset2 := make(map[ldpc.Transaction]struct{})
+	txset1 := make(map[string]struct{})
+	txset2 := make(map[string]struct{})
 
 	cnt := 0
 	for cnt < Tc {
 		tx := experiments.RandomTransaction()
 		if e1.AddTransaction(tx) {
 			e2.AddTransaction(tx)
-			txset1[*tx] = struct{}{}
-			txset2[*tx] = struct{}{}
+			txset1[string(tx.Serialized())] = struct{}{}
+			txset2[string(tx.Serialized())] = struct{}{}
 			cnt += 1
 		}
 	}
@@ -33,7 +33,7 @@ func testOverlap(T1, T2, Tc, K int) (c1, c2 int) {
 	for cnt < T1 {
 		tx := experiments.RandomTransaction()
 		if e1.AddTransaction(tx) {
-			txset1[*tx] = struct{}{}
+			txset1[string(tx.Serialized())] = struct{}{}
 			cnt += 1
 		}
 	}
@@ -41,7 +41,7 @@ func testOverlap(T1, T2, Tc, K int) (c1, c2 int) {
 	for cnt < T1 {
 		tx := experiments.RandomTransaction()
 		if e2.AddTransaction(tx) {
-			txset2[*tx] = struct{}{}
+			txset2[string(tx.Serialized())] = struct{}{}
 			cnt += 1
 		}
 	}
@@ -54,14 +54,14 @@ func testOverlap(T1, T2, Tc, K int) (c1, c2 int) {
 		_, newtx := d.AddCodeword(c1)
 		cnt1 += 1
 		for _, tx := range newtx {
-			delete(txset1, *tx.Transaction)
-			delete(txset2, *tx.Transaction)
+			delete(txset1, string(tx.Serialized()))
+			delete(txset2, string(tx.Serialized()))
 		}
 		_, newtx = d.AddCodeword(c2)
 		cnt2 += 1
 		for _, tx := range newtx {
-			delete(txset1, *tx.Transaction)
-			delete(txset2, *tx.Transaction)
+			delete(txset1, string(tx.Serialized()))
+			delete(txset2, string(tx.Serialized()))
 		}
 	}
 	return cnt1, cnt2
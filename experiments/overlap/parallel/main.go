@@ -16,22 +16,22 @@ func testOverlap(N int, commonFrac float64) (Ntx, Ncw int) {
 	e2 := ldpc.NewEncoder(experiments.TestKey, dist2, N)
 	d := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
-	txset := make(map[ldpc.Transaction]struct{})
+	txset := make(map[string]struct{})
 	nc := int(float64(N) * commonFrac)
     nd := N - nc
 
 	for i := 0; i < nc; i++ {
 		tx := experiments.RandomTransaction()
-		txset[*tx] = struct{}{}
+		txset[string(tx.Serialized())] = struct{}{}
 		e1.AddTransaction(tx)
 		e2.AddTransaction(tx)
 	}
 	for i := 0; i < nd; i++ {
 		tx := experiments.RandomTransaction()
-		txset[*tx] = struct{}{}
+		txset[string(tx.Serialized())] = struct{}{}
 		e1.AddTransaction(tx)
 		tx = experiments.RandomTransaction()
-		txset[*tx] = struct{}{}
+		txset[string(tx.Serialized())] = struct{}{}
 		e2.AddTransaction(tx)
 	}
 	ntx := len(txset)
@@ -41,11 +41,11 @@ func testOverlap(N int, commonFrac float64) (Ntx, Ncw int) {
 		c2 := e2.ProduceCodeword()
 		_, newtx := d.AddCodeword(c1)
 		for _, tx := range newtx {
-			delete(txset, *tx)
+			delete(txset, string(tx.Serialized()))
 		}
 		_, newtx = d.AddCodeword(c2)
 		for _, tx := range newtx {
-			delete(txset, *tx)
+			delete(txset, string(tx.Serialized()))
 		}
 		ncw += 2
 	}
@@ -141,27 +141,27 @@ func testOverlap(K, N int, s1, s2, common, threshold float64) {
 		e1 := ldpc.NewEncoder(experiments.TestKey, dist, K)
 		e2 := ldpc.NewEncoder(experiments.TestKey, dist, K)
 
-		txset1 := make(map[ldpc.Transaction]struct{})
-		txset2 := make(map[ldpc.Transaction]struct{})
+		txset1 := make(map[string]struct{})
+		txset2 := make(map[string]struct{})
 		c1 := 0.0
 		c2 := 0.0
 		ptr := 0
 		for i := 0; i < N; i++ {
 			if (rand.Float64() < s1) {
 				e1.AddTransaction(txs[ptr])
-				txset1[*txs[ptr]] = struct{}{}
+				txset1[string(txs[ptr].Serialized())] = struct{}{}
 				ptr++
 			}
 			if (rand.Float64() < s2) {
 				e2.AddTransaction(txs[ptr])
-				txset2[*txs[ptr]] = struct{}{}
+				txset2[string(txs[ptr].Serialized())] = struct{}{}
 				ptr++
 			}
 			if (rand.Float64() < common) {
 				e1.AddTransaction(txs[ptr])
 				e2.AddTransaction(txs[ptr])
-				txset1[*txs[ptr]] = struct{}{}
-				txset2[*txs[ptr]] = struct{}{}
+				txset1[string(txs[ptr].Serialized())] = struct{}{}
+				txset2[string(txs[ptr].Serialized())] = struct{}{}
 				ptr++
 			}
 			c1 += r1
@@ -170,8 +170,8 @@ func testOverlap(K, N int, s1, s2, common, threshold float64) {
 				c := e1.ProduceCodeword()
 				_, newtx := d1.AddCodeword(c)
 				for _, tx := range newtx {
-					delete(txset1, *tx.Transaction)
-					delete(txset2, *tx.Transaction)
+					delete(txset1, string(tx.Serialized()))
+					delete(txset2, string(tx.Serialized()))
 				}
 				c1 -= 1.0
 			}
@@ -179,8 +179,8 @@ func testOverlap(K, N int, s1, s2, common, threshold float64) {
 				c := e2.ProduceCodeword()
 				_, newtx := d1.AddCodeword(c)
 				for _, tx := range newtx {
-					delete(txset1, *tx.Transaction)
-					delete(txset2, *tx.Transaction)
+					delete(txset1, string(tx.Serialized()))
+					delete(txset2, string(tx.Serialized()))
 				}
 				c2 -= 1.0
 			}
@@ -16,24 +16,24 @@ func testOverlap(N int, commonFrac float64) (Ntx, Ncw int) {
 	e2 := ldpc.NewEncoder(experiments.TestKey, dist2, N)
 	d := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
-	txset1 := make(map[ldpc.Transaction]struct{})
-	txset2 := make(map[ldpc.Transaction]struct{})
+	txset1 := make(map[string]struct{})
+	txset2 := make(map[string]struct{})
 	nc := int(float64(N) * commonFrac)
     nd := N - nc
 
 	for i := 0; i < nc; i++ {
 		tx := experiments.RandomTransaction()
-		txset1[*tx] = struct{}{}
-		txset2[*tx] = struct{}{}
+		txset1[string(tx.Serialized())] = struct{}{}
+		txset2[string(tx.Serialized())] = struct{}{}
 		e1.AddTransaction(tx)
 		e2.AddTransaction(tx)
 	}
 	for i := 0; i < nd; i++ {
 		tx := experiments.RandomTransaction()
-		txset1[*tx] = struct{}{}
+		txset1[string(tx.Serialized())] = struct{}{}
 		e1.AddTransaction(tx)
 		tx = experiments.RandomTransaction()
-		txset2[*tx] = struct{}{}
+		txset2[string(tx.Serialized())] = struct{}{}
 		e2.AddTransaction(tx)
 	}
 	ntx := nc+nd*2
@@ -43,8 +43,8 @@ func testOverlap(N int, commonFrac float64) (Ntx, Ncw int) {
 		ncw += 1
 		_, newtx := d.AddCodeword(c1)
 		for _, tx := range newtx {
-			delete(txset1, *tx)
-			delete(txset2, *tx)
+			delete(txset1, string(tx.Serialized()))
+			delete(txset2, string(tx.Serialized()))
 		}
 	}
 	for len(txset2) > int(0.05 * float64(nc+nd)) {
@@ -52,8 +52,8 @@ func testOverlap(N int, commonFrac float64) (Ntx, Ncw int) {
 		ncw += 1
 		_, newtx := d.AddCodeword(c2)
 		for _, tx := range newtx {
-			delete(txset1, *tx)
-			delete(txset2, *tx)
+			delete(txset1, string(tx.Serialized()))
+			delete(txset2, string(tx.Serialized()))
 		}
 	}
 	return ntx, ncw
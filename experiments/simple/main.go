@@ -14,13 +14,13 @@ func testOverlap(rng *rand.Rand, t, k, n int) int {
 	e := ldpc.NewEncoder(experiments.TestKey, dist, t)
 	d := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
-	txset := make(map[ldpc.Transaction]struct{})
+	txset := make(map[string]struct{})
 
 	cnt := 0
 	for cnt < t {
 		tx := experiments.RandomTransaction()
 		if e.AddTransaction(tx) {
-			txset[*tx] = struct{}{}
+			txset[string(tx.Serialized())] = struct{}{}
 			cnt += 1
 		}
 	}
@@ -29,7 +29,7 @@ func testOverlap(rng *rand.Rand, t, k, n int) int {
 		cw := e.ProduceCodeword()
 		_, newtx := d.AddCodeword(cw)
 		for _, tx := range newtx {
-			delete(txset, *tx.Transaction)
+			delete(txset, string(tx.Serialized()))
 		}
 	}
 	return len(txset)
@@ -15,10 +15,10 @@ func testOverlap(K int, txs []*ldpc.Transaction) (float64, float64) {
 	d1 := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 
 	ptr := 0
-	txset := make(map[ldpc.Transaction]struct{})
+	txset := make(map[string]struct{})
 	for i := 0; i < K; i++ {
 		e1.AddTransaction(txs[ptr])
-		txset[*txs[ptr]] = struct{}{}
+		txset[string(txs[ptr].Serialized())] = struct{}{}
 		ptr+=1
 	}
 	cnt1 := 0
@@ -26,7 +26,7 @@ func testOverlap(K int, txs []*ldpc.Transaction) (float64, float64) {
 		c := e1.ProduceCodeword()
 		_, newtx := d1.AddCodeword(c)
 		for _, tx := range newtx {
-			delete(txset, *tx.Transaction)
+			delete(txset, string(tx.Serialized()))
 		}
 		cnt1++
 	}
@@ -38,7 +38,7 @@ func testOverlap(K int, txs []*ldpc.Transaction) (float64, float64) {
 	min := 1.0
 	test := func(rate2 float64) bool {
 		ncode = 0
-		txset2 := make(map[ldpc.Transaction]struct{})
+		txset2 := make(map[string]struct{})
 		d2 := ldpc.NewDecoder(experiments.TestKey, 2147483647)
 		e := ldpc.NewEncoder(experiments.TestKey, dist1, K)
 		for i := 0; i < K; i++ {
@@ -47,7 +47,7 @@ func testOverlap(K int, txs []*ldpc.Transaction) (float64, float64) {
 			//e.AddTransaction(txs[i])
 		}
 		for i := K; i < nc+K; i++ {
-			txset2[*txs[i]] = struct{}{}
+			txset2[string(txs[i].Serialized())] = struct{}{}
 		}
 		credit := 0.0
 		for i := 0; i < nc+K+K; i++ {
@@ -61,7 +61,7 @@ func testOverlap(K int, txs []*ldpc.Transaction) (float64, float64) {
 				ncode += 1
 				_, newtx := d2.AddCodeword(c)
 				for _, tx := range newtx {
-					delete(txset2, *tx.Transaction)
+					delete(txset2, string(tx.Serialized()))
 				}
 				credit -= 1.0
 				if len(txset2) == 0 {
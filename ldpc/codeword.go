@@ -1,6 +1,6 @@
 package ldpc
 
 type Codeword struct {
-	Symbol  TransactionData // XOR of all transactions put into this codeword
+	Symbol  TransactionData // XOR of all transactions put into this codeword, as long as the longest
 	Members []uint32        // salted hashes of transactions
 }
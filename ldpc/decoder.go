@@ -1,6 +1,7 @@
 package ldpc
 
 import (
+	"bytes"
 	"github.com/dchest/siphash"
 	"hash"
 	"sync"
@@ -116,7 +117,7 @@ func (peelable *PendingCodeword) peelTransaction(stub *pendingTransaction, preim
 			peelable.members[idx] = peelable.members[l-1]
 			peelable.members[l-1] = nil
 			peelable.members = peelable.members[:l-1]
-			peelable.symbol.XOR(&preimage.serialized)
+			peelable.symbol.XOR(preimage.serialized)
 			return
 		}
 	}
@@ -162,7 +163,8 @@ func (p *Decoder) storeNewTransaction(hash uint32, t *Transaction) {
 func (p *Decoder) AddCodeword(rawCodeword *Codeword) (*PendingCodeword, []DecodedTransaction) {
 	cw := pendingCodewordPool.Get().(*PendingCodeword)
 	cw.reset()
-	cw.symbol = rawCodeword.Symbol
+	// copy the symbol, as it is peeled in place
+	cw.symbol = append(cw.symbol[:0], rawCodeword.Symbol...)
 	for _, member := range rawCodeword.Members {
 		pending, pendingExists := p.pendingTransactions[member]
 		received, receivedExists := p.receivedTransactions[member]
@@ -181,7 +183,7 @@ func (p *Decoder) AddCodeword(rawCodeword *Codeword) (*PendingCodeword, []Decode
 			// sanity check
 			if !pendingExists {
 				// peel the transaction
-				cw.symbol.XOR(&received.serialized)
+				cw.symbol.XOR(received.serialized)
 			} else {
 				panic("transaction is marked both received and pending")
 			}
@@ -215,7 +217,7 @@ func (p *Decoder) AddTransaction(t *Transaction) []DecodedTransaction {
 			return nil
 		}
 	} else {
-		if existing.serialized == t.serialized {
+		if bytes.Equal(existing.serialized, t.serialized) {
 			// something that we already know; do not do anything
 			return nil
 		} else {
@@ -253,14 +255,13 @@ func (p *Decoder) decodeCodewords(queue []*PendingCodeword) []DecodedTransaction
 				if _, there := p.pendingTransactions[tx.saltedHash]; there {
 					// tx is now decoded, produce decoded tx
 					decodedTx := &Transaction{}
-					err := decodedTx.UnmarshalBinary(c.symbol[:])
-					if err != nil {
-						panic("error unmarshalling degree-1 codeword into transaction")
-					}
+					// a bad length prefix means the same as a bad hash: the
+					// codeword was peeled with a conflicting transaction
+					err := decodedTx.unmarshalSymbol(c.symbol)
 					p.hasher.Reset()
 					p.hasher.Write(decodedTx.hash[:])
 					computedHash := (uint32)(p.hasher.Sum64())
-					if computedHash != tx.saltedHash {
+					if err != nil || computedHash != tx.saltedHash {
 						failedTx, failed := c.failToDecode()
 						if failed {
 							delete(p.pendingTransactions, failedTx)
@@ -1,6 +1,7 @@
 package ldpc
 
 import (
+	"bytes"
 	"github.com/dchest/siphash"
 	//	"math/rand"
 	"hash"
@@ -9,12 +10,9 @@ import (
 
 var testSalt = [SaltSize]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
 var hasher hash.Hash64 = siphash.New(testSalt[:])
-var zeroTx TransactionData = TransactionData{}
-
 func randomTransaction() (*Transaction, *pendingTransaction) {
-	t1 := randomBytes()
 	tx1 := &Transaction{}
-	tx1.UnmarshalBinary(t1[:])
+	tx1.UnmarshalBinary(randomBytes(randomLength()))
 	hasher.Reset()
 	hasher.Write(tx1.hash[:])
 	tx1stub := &pendingTransaction{(uint32)(hasher.Sum64()), []*PendingCodeword{}}
@@ -22,7 +20,7 @@ func randomTransaction() (*Transaction, *pendingTransaction) {
 }
 
 func (c *PendingCodeword) addTransaction(t *Transaction, stub *pendingTransaction) {
-	c.symbol.XOR(&t.serialized)
+	c.symbol.XOR(t.serialized)
 	c.members = append(c.members, stub)
 	stub.blocking = append(stub.blocking, c)
 }
@@ -37,7 +35,7 @@ func TestPeelTransaction(t *testing.T) {
 	cw.addTransaction(tx2, tx2stub)
 
 	cw.peelTransaction(tx1stub, tx1)
-	if cw.symbol != tx2.serialized {
+	if !sameSymbol(cw.symbol, tx2.serialized) {
 		t.Error("incorrect result after peeling")
 	}
 	if len(cw.members) != 1 || cw.members[0] != tx2stub {
@@ -45,7 +43,7 @@ func TestPeelTransaction(t *testing.T) {
 	}
 
 	cw.peelTransaction(tx2stub, tx2)
-	if cw.symbol != zeroTx {
+	if !isZero(cw.symbol) {
 		t.Error("incorrect result after peeling")
 	}
 	if len(cw.members) != 0 {
@@ -68,13 +66,13 @@ func TestMarkDecoded(t *testing.T) {
 	if len(decodable) != 2 || decodable[0] != cw1 || decodable[1] != cw2 {
 		t.Error("incorrect list of decodable codewords")
 	}
-	if cw2.symbol != zeroTx {
+	if !isZero(cw2.symbol) {
 		t.Error("incorrect result after peeling")
 	}
 	if len(cw2.members) != 0 {
 		t.Error("incorrect member after peeling")
 	}
-	if cw1.symbol != tx2.serialized {
+	if !sameSymbol(cw1.symbol, tx2.serialized) {
 		t.Error("incorrect result after peeling")
 	}
 	if len(cw1.members) != 1 || cw1.members[0] != tx2stub {
@@ -123,7 +121,7 @@ func TestDecodeCodewords(t *testing.T) {
 		if len(cws[i].members) != 0 {
 			t.Error("nonempty member set of decoded codeword")
 		}
-		if cws[i].symbol != zeroTx {
+		if !isZero(cws[i].symbol) {
 			t.Error("nonzero symbol of decoded codeword")
 		}
 	}
@@ -131,9 +129,9 @@ func TestDecodeCodewords(t *testing.T) {
 		t.Error("incorrect number of pending transactions")
 	}
 	crr := TransactionData{}
-	crr.XOR(&txs[4].serialized)
-	crr.XOR(&txs[5].serialized)
-	if cws[4].symbol != crr {
+	crr.XOR(txs[4].serialized)
+	crr.XOR(txs[5].serialized)
+	if !sameSymbol(cws[4].symbol, crr) {
 		t.Error("incorrect symbol for pending codeword")
 	}
 
@@ -145,12 +143,12 @@ func TestDecodeCodewords(t *testing.T) {
 		if !there {
 			t.Error("missing decoded transaction")
 		}
-		if dec.serialized != txs[i].serialized {
+		if !bytes.Equal(dec.serialized, txs[i].serialized) {
 			t.Error("incorrect decoded transaction data")
 		}
 		found := false
 		for _, ptr := range newtx {
-			if ptr == dec {
+			if ptr.Transaction == dec {
 				found = true
 				break
 			}
@@ -206,7 +204,7 @@ func TestAddTransaction(t *testing.T) {
 		if len(cws[i].members) != 0 {
 			t.Error("nonempty member set of decoded codeword")
 		}
-		if cws[i].symbol != zeroTx {
+		if !isZero(cws[i].symbol) {
 			t.Error("nonzero symbol of decoded codeword")
 		}
 	}
@@ -219,13 +217,13 @@ func TestAddTransaction(t *testing.T) {
 		if !there {
 			t.Error("missing decoded transaction")
 		}
-		if dec.serialized != txs[i].serialized {
+		if !bytes.Equal(dec.serialized, txs[i].serialized) {
 			t.Error("incorrect decoded transaction data")
 		}
 		if i != 0 {
 			found := false
 			for _, ptr := range newtx {
-				if ptr == dec {
+				if ptr.Transaction == dec {
 					found = true
 					break
 				}
@@ -101,9 +101,16 @@ func (e *Encoder) produceCodeword(deg int) *Codeword {
 			W = W * math.Exp(math.Log(rand.Float64())/d)
 		}
 	}
+	size := 0
+	for _, item := range *selected {
+		if len(item.serialized) > size {
+			size = len(item.serialized)
+		}
+	}
+	c.Symbol = make(TransactionData, size)
 	for idx, item := range *selected {
 		c.Members[idx] = item.saltedHash
-		c.Symbol.XOR(&item.serialized)
+		c.Symbol.XOR(item.serialized)
 		(*selected)[idx].Transaction = nil // set the ptr to nil so when selected is in the pool, it does not point to some transaction and cause it to remain in GC scope
 	}
 	codewordBuilderPool.Put(selected)
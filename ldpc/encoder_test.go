@@ -2,6 +2,7 @@ package ldpc
 
 import (
 	"github.com/yangl1996/soliton"
+	"math"
 	"math/rand"
 	"testing"
 )
@@ -101,3 +102,52 @@ func BenchmarkDecode(b *testing.B) {
 	}
 	b.Logf("decoded %d out of %d (%.2f%%)", ndec, b.N, float64(ndec)/float64(b.N)*100.0)
 }
+
+// realisticLength returns the length of a transaction drawn log-uniformly
+// from 100 bytes to maxRealisticLength.
+func realisticLength(r *rand.Rand) int {
+	return int(100 * math.Pow(maxRealisticLength/100.0, r.Float64()))
+}
+
+const maxRealisticLength = 4096
+
+// BenchmarkBandwidth reports the symbol bytes sent per transaction to decode
+// windows of transactions of realistic lengths, with the symbols sized to
+// the longest member, and with every transaction padded to the longest
+// possible as a fixed-size codec does.
+func BenchmarkBandwidth(b *testing.B) {
+	for _, padded := range []bool{false, true} {
+		name := "variable"
+		if padded {
+			name = "padded"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(0))
+			const window = 50
+			symbolBytes, payloadBytes := 0, 0
+			for i := 0; i < b.N; i++ {
+				dist := soliton.NewRobustSoliton(r, window, 0.03, 0.5)
+				e := NewEncoder(testSalt, dist, window)
+				for j := 0; j < window; j++ {
+					d := make([]byte, realisticLength(r))
+					r.Read(d)
+					payloadBytes += len(d)
+					if padded {
+						d = append(d, make([]byte, maxRealisticLength-len(d))...)
+					}
+					tx := &Transaction{}
+					tx.UnmarshalBinary(d)
+					e.AddTransaction(tx)
+				}
+				dec := NewDecoder(testSalt, 262144)
+				for dec.NumTransactionsReceived() < window {
+					c := e.ProduceCodeword()
+					symbolBytes += len(c.Symbol)
+					dec.AddCodeword(c)
+				}
+			}
+			b.ReportMetric(float64(symbolBytes)/float64(b.N*window), "symbolB/tx")
+			b.ReportMetric(float64(symbolBytes)/float64(payloadBytes), "symbolB/payloadB")
+		})
+	}
+}
@@ -3,19 +3,24 @@ package ldpc
 import (
 	"hash"
 	"sync"
-	"unsafe"
 
+	"github.com/yangl1996/rateless-set-reconcile/prefixed"
 	"golang.org/x/crypto/blake2b"
 )
 
-const TxSize = 128 // size of a serialized transaction in bytes
+const (
+	TxSize    = 128                  // size of the fixed-size transactions used in experiments
+	MaxTxSize = prefixed.MaxDataSize // max size of a serialized transaction in bytes
+)
 
-type TransactionData [TxSize]byte
+// TransactionData is the XOR of transactions, each prefixed by its length and
+// padded with zeros to the longest of them, as laid out by package prefixed.
+type TransactionData []byte
 
-func (t *TransactionData) XOR(t2 *TransactionData) {
-	for i := 0; i < TxSize/8; i++ {
-		*(*uint64)(unsafe.Pointer(&t[i*8])) ^= *(*uint64)(unsafe.Pointer(&t2[i*8]))
-	}
+// XOR sets t to the XOR of t and t2, first padding t with zeros to the length
+// of t2 if it is shorter.
+func (t *TransactionData) XOR(t2 TransactionData) {
+	*t = prefixed.XOR(*t, t2)
 }
 
 var hasherPool = sync.Pool{
@@ -26,32 +31,39 @@ var hasherPool = sync.Pool{
 }
 
 type Transaction struct {
-	serialized TransactionData
+	serialized TransactionData // prefixed by its length
 	hash       [blake2b.Size]byte
 }
 
 func (t *Transaction) Serialized() []byte {
-	return t.serialized[:]
+	return t.serialized[prefixed.PrefixSize:]
 }
 
 func (t *Transaction) UnmarshalBinary(data []byte) error {
 	// check transaction size
-	if len(data) != TxSize {
+	if len(data) > MaxTxSize {
 		return DataSizeError{len(data)}
 	}
-	copied := copy(t.serialized[0:TxSize], data[0:TxSize])
-	if copied != TxSize {
-		panic("incorrect number of bytes copied")
-	}
+	t.serialized = prefixed.Prefix(data)
 
 	h := hasherPool.Get().(hash.Hash)
 	defer hasherPool.Put(h)
 	h.Reset()
-	h.Write(t.serialized[:])
+	h.Write(data)
 	h.Sum(t.hash[0:0]) // Sum appends to the given slice
 	return nil
 }
 
+// unmarshalSymbol sets t to the only transaction in the symbol s, trimming
+// the padding after it.
+func (t *Transaction) unmarshalSymbol(s TransactionData) error {
+	data, ok := prefixed.Data(s)
+	if !ok {
+		return DataSizeError{len(s)}
+	}
+	return t.UnmarshalBinary(data)
+}
+
 type DataSizeError struct {
 	length int
 }
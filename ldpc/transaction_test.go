@@ -1,65 +1,135 @@
 package ldpc
 
 import (
+	"bytes"
 	"golang.org/x/crypto/blake2b"
 	"math/rand"
 	"testing"
 )
 
-func randomBytes() TransactionData {
-	d := TransactionData{}
-	rand.Read(d[:])
+func randomBytes(n int) []byte {
+	d := make([]byte, n)
+	rand.Read(d)
 	return d
 }
 
+// randomLength returns the length of a random transaction, which is between
+// a few bytes and several times TxSize.
+func randomLength() int {
+	return 8 + rand.Intn(4*TxSize)
+}
+
+// sameSymbol returns if the symbols a and b are equal but for the zeros
+// padding them.
+func sameSymbol(a, b TransactionData) bool {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	return bytes.Equal(a[:len(b)], b) && isZero(a[len(b):])
+}
+
+func isZero(s []byte) bool {
+	for _, b := range s {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // BenchmarkXOR benchmarks XORing transaction data.
 func BenchmarkXORTransaction(b *testing.B) {
-	t1 := randomBytes()
+	t1 := TransactionData(randomBytes(TxSize))
 	t2 := TransactionData{}
 	b.ReportAllocs()
 	b.SetBytes(TxSize)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		t2.XOR(&t1)
+		t2.XOR(t1)
 	}
 }
 
 // TestXORTransaction tests XORing two transactions.
 func TestXORTransaction(t *testing.T) {
-	t1 := randomBytes()
-	t2 := randomBytes()
+	t1 := TransactionData(randomBytes(TxSize))
+	t2 := TransactionData(randomBytes(TxSize / 2))
 	c := TransactionData{}
-	c.XOR(&t1)
-	if c != t1 {
+	c.XOR(t1)
+	if !bytes.Equal(c, t1) {
 		t.Error("incorrect bytes after XOR")
 	}
-	c.XOR(&t2)
-	var shouldBe TransactionData
+	c.XOR(t2)
+	shouldBe := make(TransactionData, TxSize)
 	for i := 0; i < TxSize; i++ {
-		shouldBe[i] = t1[i] ^ t2[i]
+		shouldBe[i] = t1[i]
+		if i < len(t2) {
+			shouldBe[i] ^= t2[i]
+		}
 	}
-	if c != shouldBe {
+	if !bytes.Equal(c, shouldBe) {
+		t.Error("incorrect bytes after XOR")
+	}
+}
+
+// TestXORTransactionPadding tests that XORing with a longer symbol pads with
+// zeros, even when the shorter one has spare capacity.
+func TestXORTransactionPadding(t *testing.T) {
+	t1 := TransactionData(randomBytes(TxSize))
+	c := TransactionData(randomBytes(TxSize))[:TxSize/2]
+	short := append(TransactionData{}, c...)
+	c.XOR(t1)
+	c.XOR(short)
+	if !bytes.Equal(c, t1) {
 		t.Error("incorrect bytes after XOR")
 	}
 }
 
 // TestUnmarshalTransaction tests unmarshalling of transaction data.
 func TestUnmarshalTransaction(t *testing.T) {
-	d := randomBytes()
 	tx := &Transaction{}
-	err := tx.UnmarshalBinary(d[0 : TxSize-1])
+	err := tx.UnmarshalBinary(randomBytes(MaxTxSize + 1))
 	if _, ok := err.(DataSizeError); !ok {
 		t.Error("failed to report data size mismatch")
 	}
-	err = tx.UnmarshalBinary(d[:])
-	if err != nil {
-		t.Error("error unmarshalling")
+	for _, n := range []int{0, 1, TxSize, MaxTxSize} {
+		d := randomBytes(n)
+		err = tx.UnmarshalBinary(d)
+		if err != nil {
+			t.Error("error unmarshalling")
+		}
+		if !bytes.Equal(tx.Serialized(), d) {
+			t.Error("data corrupted during unmarshalling")
+		}
+		correctHash := blake2b.Sum512(d)
+		if correctHash != tx.hash {
+			t.Error("incorrect hash after unmarshalling")
+		}
+	}
+}
+
+// TestUnmarshalSymbol tests recovering a transaction from a symbol padded
+// by a longer transaction peeled from it.
+func TestUnmarshalSymbol(t *testing.T) {
+	short, long := &Transaction{}, &Transaction{}
+	short.UnmarshalBinary(randomBytes(TxSize / 2))
+	long.UnmarshalBinary(randomBytes(TxSize * 2))
+	s := TransactionData{}
+	s.XOR(short.serialized)
+	s.XOR(long.serialized)
+	s.XOR(long.serialized)
+	if len(s) != len(long.serialized) {
+		t.Error("symbol not padded to the longest transaction")
+	}
+	tx := &Transaction{}
+	if err := tx.unmarshalSymbol(s); err != nil {
+		t.Fatal("error unmarshalling symbol")
 	}
-	if tx.serialized != d {
-		t.Error("data corrupted during unmarshalling")
+	if !bytes.Equal(tx.Serialized(), short.Serialized()) || tx.hash != short.hash {
+		t.Error("incorrect transaction from symbol")
 	}
-	correctHash := blake2b.Sum512(d[:])
-	if correctHash != tx.hash {
-		t.Error("incorrect hash after unmarshalling")
+	// a length beyond the symbol
+	s = TransactionData{0xff, 0xff, 0}
+	if _, ok := tx.unmarshalSymbol(s).(DataSizeError); !ok {
+		t.Error("failed to report bad length prefix")
 	}
 }
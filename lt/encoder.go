@@ -11,6 +11,12 @@ type Codeword[T TransactionData[T]] struct {
 	members []uint32
 }
 
+// MembershipSize returns the number of bytes c takes on the wire besides the
+// symbol.
+func (c Codeword[T]) MembershipSize() int {
+	return uvarintSize(uint64(len(c.members))) + 4*len(c.members)
+}
+
 const SaltSize = 16
 
 type DegreeDistribution interface {
@@ -29,8 +35,11 @@ type Encoder[T TransactionData[T]] struct {
 	degreeDist DegreeDistribution
 	hashes     map[uint32]struct{} // transactions already in the window
 	windowSize int
+	nextSeq    uint64   // sequence number of the next transaction added
+	announced  []uint64 // nextSeq when producing the latest seeded codewords, oldest first
 
 	shuffleHistory []int
+	sampled        []int
 }
 
 func NewEncoder[T TransactionData[T]](r *rand.Rand, salt [SaltSize]byte, dist DegreeDistribution, ws int) *Encoder[T] {
@@ -64,6 +73,7 @@ func (e *Encoder[T]) AddTransaction(t Transaction[T]) bool {
 	tx := saltedTransaction[T]{hash, t}
 	e.window = append(e.window, tx)
 	e.hashes[hash] = struct{}{}
+	e.nextSeq += 1
 	for len(e.window) > e.windowSize {
 		delete(e.hashes, e.window[0].saltedHash)
 		e.window = e.window[1:]
@@ -0,0 +1,58 @@
+package lt
+
+import (
+	"errors"
+	"github.com/yangl1996/rateless-set-reconcile/prefixed"
+)
+
+const MaxPayloadSize = prefixed.MaxDataSize // max length of the data of a Payload in bytes
+
+var ErrPayloadSize = errors.New("payload larger than MaxPayloadSize")
+
+// Payload is transaction data of variable length. It holds the XOR of
+// payloads laid out by package prefixed, so that a codeword is as long as its
+// longest member and a payload decoded from it is trimmed to its true length.
+type Payload struct {
+	symbol []byte
+}
+
+// NewPayload returns a payload holding a copy of data.
+func NewPayload(data []byte) (*Payload, error) {
+	if len(data) > MaxPayloadSize {
+		return nil, ErrPayloadSize
+	}
+	return &Payload{prefixed.Prefix(data)}, nil
+}
+
+// XOR sets p to the XOR of p and t2, first padding p with zeros to the length
+// of t2 if it is shorter. A nil p is taken as empty.
+func (p *Payload) XOR(t2 *Payload) *Payload {
+	if p == nil {
+		p = &Payload{}
+	}
+	p.symbol = prefixed.XOR(p.symbol, t2.symbol)
+	return p
+}
+
+// Hash returns the length-prefixed data in p without the padding, so that a
+// payload decoded from a codeword hashes the same as the original.
+func (p *Payload) Hash() []byte {
+	if len(p.symbol) < prefixed.PrefixSize {
+		return p.symbol
+	}
+	data, _ := prefixed.Data(p.symbol)
+	return p.symbol[:prefixed.PrefixSize+len(data)]
+}
+
+// Bytes returns the data in p without the padding. A corrupt length prefix is
+// clamped to the symbol.
+func (p *Payload) Bytes() []byte {
+	data, _ := prefixed.Data(p.symbol)
+	return data
+}
+
+// Size returns the number of bytes p takes on the wire, including the
+// length prefix and the padding.
+func (p *Payload) Size() int {
+	return len(p.symbol)
+}
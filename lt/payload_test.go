@@ -0,0 +1,114 @@
+package lt
+
+import (
+	"bytes"
+	"github.com/yangl1996/soliton"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomPayload(r *rand.Rand, n int) *Payload {
+	d := make([]byte, n)
+	r.Read(d)
+	p, err := NewPayload(d)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestPayloadXOR(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	short := randomPayload(r, 10)
+	long := randomPayload(r, 100)
+	var sum *Payload
+	sum = sum.XOR(short).XOR(long)
+	if sum.Size() != long.Size() {
+		t.Error("symbol not padded to the longest payload")
+	}
+	sum = sum.XOR(long)
+	if !bytes.Equal(sum.Bytes(), short.Bytes()) || !bytes.Equal(sum.Hash(), short.Hash()) {
+		t.Error("incorrect payload after peeling")
+	}
+	if _, err := NewPayload(make([]byte, MaxPayloadSize+1)); err != ErrPayloadSize {
+		t.Error("failed to report payload size")
+	}
+	// a corrupt length prefix does not take us out of the symbol
+	bad := &Payload{[]byte{0xff, 0xff, 1}}
+	if len(bad.Bytes()) != 1 || len(bad.Hash()) != 3 {
+		t.Error("corrupt length prefix not clamped")
+	}
+}
+
+func TestEncodeAndDecodePayloads(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	dist := soliton.NewRobustSoliton(rand.New(rand.NewSource(0)), 500, 0.03, 0.5)
+	e := NewEncoder[*Payload](rand.New(rand.NewSource(0)), testSalt, dist, 500)
+	sent := make(map[string]struct{})
+	for i := 0; i < 500; i++ {
+		p := randomPayload(r, r.Intn(1000))
+		sent[string(p.Bytes())] = struct{}{}
+		e.AddTransaction(NewTransaction[*Payload](p))
+	}
+	dec := NewDecoder[*Payload](testSalt, 100000)
+	ndec := 0
+	for ndec < 500 {
+		_, newtx := dec.AddCodeword(e.ProduceCodeword())
+		for _, tx := range newtx {
+			if _, there := sent[string(tx.Data().Bytes())]; !there {
+				t.Fatal("decoded payload never sent")
+			}
+			delete(sent, string(tx.Data().Bytes()))
+		}
+		ndec += len(newtx)
+	}
+}
+
+// realisticPayloadSize returns a payload size drawn log-uniformly from 100
+// bytes to maxRealisticPayloadSize.
+func realisticPayloadSize(r *rand.Rand) int {
+	return int(100 * math.Pow(maxRealisticPayloadSize/100.0, r.Float64()))
+}
+
+const maxRealisticPayloadSize = 4096
+
+// BenchmarkPayloadBandwidth reports the symbol bytes sent per transaction to
+// decode windows of payloads of realistic sizes, with the symbols sized to
+// the longest member, and with every payload padded to the longest possible
+// as a fixed-size codec does.
+func BenchmarkPayloadBandwidth(b *testing.B) {
+	for _, padded := range []bool{false, true} {
+		name := "variable"
+		if padded {
+			name = "padded"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(0))
+			const window = 500
+			symbolBytes, payloadBytes := 0, 0
+			for i := 0; i < b.N; i++ {
+				dist := soliton.NewRobustSoliton(r, window, 0.03, 0.5)
+				e := NewEncoder[*Payload](r, testSalt, dist, window)
+				for j := 0; j < window; j++ {
+					n := realisticPayloadSize(r)
+					payloadBytes += n
+					if padded {
+						n = maxRealisticPayloadSize
+					}
+					e.AddTransaction(NewTransaction[*Payload](randomPayload(r, n)))
+				}
+				dec := NewDecoder[*Payload](testSalt, 100000)
+				ndec := 0
+				for ndec < window {
+					c := e.ProduceCodeword()
+					symbolBytes += c.symbol.Size()
+					_, newtx := dec.AddCodeword(c)
+					ndec += len(newtx)
+				}
+			}
+			b.ReportMetric(float64(symbolBytes)/float64(b.N*window), "symbolB/tx")
+			b.ReportMetric(float64(symbolBytes)/float64(payloadBytes), "symbolB/payloadB")
+		})
+	}
+}
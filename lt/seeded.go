@@ -0,0 +1,225 @@
+package lt
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// A seeded codeword does not list its members. Instead, it carries the seed
+// from which the encoder sampled them out of its window, and the range of
+// sequence numbers the window spans; the encoder numbers the transactions in
+// the order they are added. To map sequence numbers back to transactions, a
+// seeded codeword also announces the salted hashes of the transactions added
+// to the window since the last announceRepeat seeded codewords, which the
+// receiver keeps in a WindowMirror. Each transaction thus costs 4 bytes in
+// announceRepeat codewords, instead of 4 bytes in every codeword it is a
+// member of, and the mirror survives losing up to announceRepeat-1 seeded
+// codewords in a row.
+//
+// The mirror falls out of sync with the window when more seeded codewords are
+// lost in a row, or when it forgets hashes that the window still holds. The
+// codewords with members it cannot resolve are rejected with
+// ErrWindowMismatch, as are codewords whose window and announcements do not
+// add up.
+
+// announceRepeat is the number of consecutive seeded codewords that announce
+// each transaction.
+const announceRepeat = 3
+
+var ErrWindowMismatch = errors.New("codeword members not in the window mirror")
+
+type SeededCodeword[T TransactionData[T]] struct {
+	symbol    T
+	seed      uint64
+	degree    int
+	windowEnd uint64   // sequence number after the newest transaction in the window
+	windowLen int      // number of transactions in the window
+	announce  []uint32 // salted hashes of the newest transactions in the window
+}
+
+// MembershipSize returns the number of bytes c takes on the wire besides the
+// symbol.
+func (c SeededCodeword[T]) MembershipSize() int {
+	return 8 + uvarintSize(uint64(c.degree)) + uvarintSize(c.windowEnd) + uvarintSize(uint64(c.windowLen)) + uvarintSize(uint64(len(c.announce))) + 4*len(c.announce)
+}
+
+// ListedMembershipSize returns the number of bytes the members of c would
+// take if listed as in Codeword.
+func (c SeededCodeword[T]) ListedMembershipSize() int {
+	return uvarintSize(uint64(c.degree)) + 4*c.degree
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n += 1
+	}
+	return n
+}
+
+// seedRand is a splitmix64 generator, which is cheap enough to seed for
+// every codeword.
+type seedRand uint64
+
+func (r *seedRand) uint64() uint64 {
+	*r += 0x9e3779b97f4a7c15
+	z := uint64(*r)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a number in [0, n).
+func (r *seedRand) intn(n int) int {
+	hi, _ := bits.Mul64(r.uint64(), uint64(n))
+	return int(hi)
+}
+
+// sampleIndices appends to res deg distinct indices in [0, n) sampled with
+// the seed, and returns it. It is a Fisher-Yates shuffle that only keeps the
+// positions it has moved.
+func sampleIndices(seed uint64, n, deg int, res []int) []int {
+	r := seedRand(seed)
+	moved := make(map[int]int, deg)
+	at := func(i int) int {
+		if v, there := moved[i]; there {
+			return v
+		}
+		return i
+	}
+	for i := 0; i < deg; i++ {
+		j := i + r.intn(n-i)
+		vi, vj := at(i), at(j)
+		moved[j] = vi
+		res = append(res, vj)
+	}
+	return res
+}
+
+func (e *Encoder[T]) ProduceSeededCodeword() SeededCodeword[T] {
+	deg := int(e.degreeDist.Uint64())
+	return e.produceSeededCodeword(deg, e.r.Uint64())
+}
+
+func (e *Encoder[T]) produceSeededCodeword(deg int, seed uint64) SeededCodeword[T] {
+	c := SeededCodeword[T]{}
+	if deg > len(e.window) {
+		deg = len(e.window)
+	}
+	if deg == 0 {
+		panic("trying to produce codeword with degree zero")
+	}
+	c.seed = seed
+	c.degree = deg
+	c.windowEnd = e.nextSeq
+	c.windowLen = len(e.window)
+	e.sampled = sampleIndices(seed, len(e.window), deg, e.sampled[:0])
+	for _, idx := range e.sampled {
+		c.symbol = c.symbol.XOR(e.window[idx].Transaction.data)
+	}
+	// announce the transactions added since the last announceRepeat seeded
+	// codewords, which are the newest
+	windowStart := e.nextSeq - uint64(len(e.window))
+	from := windowStart
+	if len(e.announced) == announceRepeat {
+		from = e.announced[0]
+		e.announced = append(e.announced[:0], e.announced[1:]...)
+	}
+	if from < windowStart {
+		from = windowStart
+	}
+	for _, tx := range e.window[from-windowStart:] {
+		c.announce = append(c.announce, tx.saltedHash)
+	}
+	e.announced = append(e.announced, e.nextSeq)
+	return c
+}
+
+type mirrorSlot struct {
+	saltedHash uint32
+	known      bool
+}
+
+// WindowMirror keeps the salted hashes of the transactions in the window of
+// a remote encoder, as announced in its seeded codewords.
+type WindowMirror struct {
+	first  uint64 // sequence number of slots[0]
+	slots  []mirrorSlot
+	memory int
+
+	sampled []int
+}
+
+// NewWindowMirror returns a mirror that remembers the hashes of the latest
+// memory transactions.
+func NewWindowMirror(memory int) *WindowMirror {
+	return &WindowMirror{memory: memory}
+}
+
+// update records the salted hashes announced for the transactions just
+// before windowEnd, in a window of windowLen transactions. It returns
+// ErrWindowMismatch if the announcements do not fit in the window.
+func (w *WindowMirror) update(windowEnd uint64, windowLen int, announce []uint32) error {
+	if uint64(len(announce)) > windowEnd || len(announce) > windowLen {
+		return ErrWindowMismatch
+	}
+	start := windowEnd - uint64(len(announce))
+	if end := w.first + uint64(len(w.slots)); windowEnd > end {
+		if windowEnd-end > uint64(w.memory) {
+			// we would forget all we have
+			w.slots = w.slots[:0]
+			w.first = windowEnd - uint64(w.memory)
+			end = w.first
+		}
+		// slots not announced in codewords we did not get stay unknown
+		w.slots = append(w.slots, make([]mirrorSlot, windowEnd-end)...)
+	}
+	for i, h := range announce {
+		seq := start + uint64(i)
+		if seq >= w.first {
+			w.slots[seq-w.first] = mirrorSlot{h, true}
+		}
+	}
+	if extra := len(w.slots) - w.memory; extra > 0 {
+		w.slots = w.slots[extra:]
+		w.first += uint64(extra)
+	}
+	return nil
+}
+
+// members returns the salted hashes of the members sampled with the seed
+// out of the window of windowLen transactions before windowEnd.
+func (w *WindowMirror) members(windowEnd uint64, windowLen, degree int, seed uint64) ([]uint32, error) {
+	if windowLen < degree || uint64(windowLen) > windowEnd {
+		return nil, ErrWindowMismatch
+	}
+	windowStart := windowEnd - uint64(windowLen)
+	w.sampled = sampleIndices(seed, windowLen, degree, w.sampled[:0])
+	members := make([]uint32, 0, degree)
+	for _, idx := range w.sampled {
+		seq := windowStart + uint64(idx)
+		if seq < w.first || seq-w.first >= uint64(len(w.slots)) || !w.slots[seq-w.first].known {
+			return nil, ErrWindowMismatch
+		}
+		members = append(members, w.slots[seq-w.first].saltedHash)
+	}
+	return members, nil
+}
+
+// AddSeededCodeword is AddCodeword for a seeded codeword, whose members it
+// resolves with w, the mirror of the window of the encoder that produced it.
+// It returns ErrWindowMismatch, and does not add the codeword, if some of the
+// members are not in the mirror, or if its announcements do not fit in its
+// window.
+func (p *Decoder[T]) AddSeededCodeword(w *WindowMirror, c SeededCodeword[T]) (*PendingCodeword[T], []Transaction[T], error) {
+	if err := w.update(c.windowEnd, c.windowLen, c.announce); err != nil {
+		return nil, nil, err
+	}
+	members, err := w.members(c.windowEnd, c.windowLen, c.degree, c.seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	cw, txs := p.AddCodeword(Codeword[T]{c.symbol, members})
+	return cw, txs, nil
+}
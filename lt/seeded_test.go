@@ -0,0 +1,162 @@
+package lt
+
+import (
+	"github.com/yangl1996/soliton"
+	"math/rand"
+	"testing"
+)
+
+func TestSampleIndices(t *testing.T) {
+	for _, n := range []int{1, 5, 50, 1000} {
+		for _, deg := range []int{1, n / 2, n} {
+			if deg == 0 {
+				continue
+			}
+			res := sampleIndices(uint64(n*deg), n, deg, nil)
+			again := sampleIndices(uint64(n*deg), n, deg, nil)
+			seen := make(map[int]struct{})
+			for i, idx := range res {
+				if idx < 0 || idx >= n {
+					t.Fatal("index out of range")
+				}
+				if _, there := seen[idx]; there {
+					t.Fatal("duplicate index")
+				}
+				seen[idx] = struct{}{}
+				if again[i] != idx {
+					t.Fatal("indices differ for the same seed")
+				}
+			}
+			if len(res) != deg {
+				t.Error("incorrect number of indices")
+			}
+		}
+	}
+}
+
+func TestEncodeAndDecodeSeeded(t *testing.T) {
+	dist := soliton.NewRobustSoliton(rand.New(rand.NewSource(0)), 500, 0.03, 0.5)
+	e := NewEncoder[*simpleData](rand.New(rand.NewSource(0)), testSalt, dist, 500)
+	dec := NewDecoder[*simpleData](testSalt, 100000)
+	w := NewWindowMirror(1000)
+	// two windows, so that the mirror follows a reset
+	for round := 0; round < 2; round++ {
+		e.Reset(dist, 500)
+		for i := 0; i < 500; i++ {
+			tx := NewTransaction[*simpleData](newSimpleData(uint64(round*500 + i)))
+			e.AddTransaction(tx)
+		}
+		ncw, seededSize, listedSize := 0, 0, 0
+		for {
+			c := e.ProduceSeededCodeword()
+			seededSize += c.MembershipSize()
+			listedSize += c.ListedMembershipSize()
+			if _, _, err := dec.AddSeededCodeword(w, c); err != nil {
+				t.Fatal(err)
+			}
+			ncw += 1
+			decoded := true
+			for _, tx := range e.window {
+				if _, there := dec.receivedTransactions[tx.saltedHash]; !there {
+					decoded = false
+				}
+			}
+			if decoded {
+				break
+			}
+		}
+		t.Logf("%d codewords until fully decoded, %d bytes of membership, %d if listed", ncw, seededSize, listedSize)
+		if seededSize >= listedSize {
+			t.Error("seeded codewords not smaller than listed ones")
+		}
+	}
+}
+
+func TestDecodeSeededWithLoss(t *testing.T) {
+	dist := soliton.NewRobustSoliton(rand.New(rand.NewSource(0)), 500, 0.03, 0.5)
+	e := NewEncoder[*simpleData](rand.New(rand.NewSource(0)), testSalt, dist, 500)
+	dec := NewDecoder[*simpleData](testSalt, 100000)
+	w := NewWindowMirror(1000)
+	r := rand.New(rand.NewSource(1))
+	seq := uint64(0)
+	lostInRow, lost := 0, 0
+	// keep adding transactions while coding, so that every codeword has
+	// something new to announce
+	for ncw := 0; ncw < 20000; ncw++ {
+		if ncw%2 == 0 {
+			e.AddTransaction(NewTransaction[*simpleData](newSimpleData(seq)))
+			seq += 1
+		}
+		c := e.ProduceSeededCodeword()
+		if lostInRow < announceRepeat-1 && r.Intn(3) == 0 {
+			lostInRow += 1
+			lost += 1
+			continue
+		}
+		lostInRow = 0
+		if _, _, err := dec.AddSeededCodeword(w, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the transactions that left the window a while ago should be decoded
+	for i := uint64(0); i < seq-1000; i++ {
+		tx := NewTransaction[*simpleData](newSimpleData(i))
+		e.hasher.Reset()
+		e.hasher.Write(tx.hash[:])
+		if _, there := dec.receivedTransactions[uint32(e.hasher.Sum64())]; !there {
+			t.Fatal("transaction", i, "not decoded after losing", lost, "codewords")
+		}
+	}
+}
+
+func TestWindowMismatch(t *testing.T) {
+	dist := soliton.NewRobustSoliton(rand.New(rand.NewSource(0)), 50, 0.03, 0.5)
+	e := NewEncoder[*simpleData](rand.New(rand.NewSource(0)), testSalt, dist, 50)
+	dec := NewDecoder[*simpleData](testSalt, 100000)
+	w := NewWindowMirror(100)
+	for i := 0; i < 25; i++ {
+		e.AddTransaction(NewTransaction[*simpleData](newSimpleData(uint64(i))))
+	}
+	// lost with the announcements of the first 25 transactions
+	for i := 0; i < announceRepeat; i++ {
+		e.produceSeededCodeword(1, 0)
+	}
+	for i := 25; i < 50; i++ {
+		e.AddTransaction(NewTransaction[*simpleData](newSimpleData(uint64(i))))
+	}
+	mismatched, matched := 0, 0
+	for seed := uint64(0); seed < 100; seed++ {
+		c := e.produceSeededCodeword(1, seed)
+		_, _, err := dec.AddSeededCodeword(w, c)
+		newest := e.sampled[0] >= 25
+		if err == nil {
+			matched += 1
+		} else {
+			mismatched += 1
+		}
+		if (err == nil) != newest {
+			t.Fatal("codeword", seed, "with member", e.sampled[0], "got error", err)
+		}
+	}
+	if matched == 0 || mismatched == 0 {
+		t.Error("no mismatch or no match")
+	}
+
+	// announcements that do not fit in the window
+	c := e.produceSeededCodeword(1, 0)
+	c.windowLen = len(c.announce) - 1
+	if _, _, err := dec.AddSeededCodeword(w, c); err != ErrWindowMismatch {
+		t.Error("mirror did not report announcements longer than the window")
+	}
+	c.windowLen, c.windowEnd = len(c.announce), uint64(len(c.announce)-1)
+	if _, _, err := dec.AddSeededCodeword(w, c); err != ErrWindowMismatch {
+		t.Error("mirror did not report announcements before the first sequence number")
+	}
+
+	// forgetting the window
+	w = NewWindowMirror(10)
+	_, _, err := dec.AddSeededCodeword(w, e.produceSeededCodeword(50, 0))
+	if err != ErrWindowMismatch {
+		t.Error("mirror did not report forgotten members")
+	}
+}
@@ -27,7 +27,7 @@ func main() {
 	controlOverhead := flag.Float64("c", 0.10, "control overhead (ratio between the max number of codewords sent after a block is decoded and the block size)")
 	topologyFile := flag.String("topo", "", "topology file")
 	numShards := flag.Int("s", 64, "number of shards to use")
-	algorithm := flag.String("a", "coding", "algorithm to use, options are coding and pull")
+	algorithm := flag.String("a", "coding", "algorithm to use, options are coding, multicoding and pull")
 	initialFlood := flag.Bool("flood", false, "flood the transaction for the first hop")
 	flag.Parse()
 
@@ -57,6 +57,8 @@ func main() {
 		switch *algorithm {
 		case "coding":
 			connectCodingServers(servers[conn.a], servers[conn.b], conn.delay, senderConfig)
+		case "multicoding":
+			connectMultiCodingServers(servers[conn.a], servers[conn.b], conn.delay, senderConfig)
 		case"pull":
 			connectPullServers(servers[conn.a], servers[conn.b], conn.delay)
 		}
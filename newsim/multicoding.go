@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/yangl1996/rateless-set-reconcile/des"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+	"time"
+)
+
+// multiDecoders holds the decoder each server shares among the coded symbol
+// streams from all its peers.
+var multiDecoders = make(map[*server]*riblt.MultiDecoder[transaction])
+
+// All links use the same shard schedule and shard hash, so that the streams
+// a server receives tend to cover the same shard at the same time, and
+// symbols recovered from one stream can be peeled off the others.
+var multiShardSchedule []int
+var multiShardRandomizer uint64
+
+// connectMultiCodingServers connects a and b like connectCodingServers, but
+// b decodes the codewords from a with the decoder it shares among its peers.
+func connectMultiCodingServers(a, b *server, delay time.Duration, config senderConfig) {
+	if multiShardSchedule == nil {
+		multiShardSchedule = RNG.Perm(config.numShards)
+		multiShardRandomizer = RNG.Uint64()
+	}
+	dec, there := multiDecoders[b]
+	if !there {
+		dec = &riblt.MultiDecoder[transaction]{}
+		multiDecoders[b] = dec
+	}
+	a.handlers[b] = peer{coding{
+		sender: &sender{
+			Encoder:         &riblt.Encoder[transaction]{},
+			senderConfig:    config,
+			sendWindow:      1, // otherwise tryFillSendWindow always returns
+			shardSchedule:   multiShardSchedule,
+			shardRandomizer: multiShardRandomizer,
+		},
+		receiver: nil,
+	}, delay}
+	a.peers = append(a.peers, b)
+	r := &multiReceiver{
+		MultiDecoder:         dec,
+		currentBlockReceived: true, // no block until the first codeword
+		shardRandomizer:      multiShardRandomizer,
+	}
+	r.stream = dec.AddStream(r.accepts)
+	b.handlers[a] = peer{multiCoding{r}, delay}
+	b.peers = append(b.peers, a)
+}
+
+// multiCoding is the receiving end of a link; the sending end is coding
+// with a nil receiver.
+type multiCoding struct {
+	*multiReceiver
+}
+
+func (c multiCoding) collectOutgoingMessages(peer des.Module, delay time.Duration, outbox []des.OutgoingMessage) []des.OutgoingMessage {
+	for _, msg := range c.outbox {
+		outbox = append(outbox, des.OutgoingMessage{msg, peer, delay})
+	}
+	c.outbox = c.outbox[:0]
+	return outbox
+}
+
+func (c multiCoding) forwardTransaction(tx riblt.HashedSymbol[transaction]) {
+	c.onTransaction(tx)
+}
+
+func (c multiCoding) handleMessage(msg any) []riblt.HashedSymbol[transaction] {
+	switch m := msg.(type) {
+	case codeword:
+		remote, decoded := c.onCodeword(m)
+		if decoded {
+			return remote
+		} else {
+			return nil
+		}
+	default:
+		panic("unknown message type")
+	}
+}
+
+type multiReceiver struct {
+	*riblt.MultiDecoder[transaction]
+	stream int
+	buffer []riblt.HashedSymbol[transaction]
+
+	currentBlockReceived bool
+	startHash            uint64
+	endHash              uint64
+	shardRandomizer      uint64
+
+	// outgoing msgs
+	outbox []any
+}
+
+func inShard(hash, randomizer, startHash, endHash uint64) bool {
+	shardHash := hash * randomizer
+	if startHash < endHash {
+		return shardHash >= startHash && shardHash < endHash
+	} else {
+		return shardHash >= startHash || shardHash < endHash
+	}
+}
+
+// accepts returns whether tx is in the shard of the current block, so that
+// a transaction recovered from another stream is only shared with this one
+// when the sender may have put it in the block.
+func (n *multiReceiver) accepts(tx riblt.HashedSymbol[transaction]) bool {
+	return !n.currentBlockReceived && inShard(tx.Hash, n.shardRandomizer, n.startHash, n.endHash)
+}
+
+func (n *multiReceiver) onCodeword(cw codeword) ([]riblt.HashedSymbol[transaction], bool) {
+	if n.currentBlockReceived && !cw.newBlock {
+		return nil, false
+	}
+	ack := ack{}
+	if cw.newBlock {
+		ack.ackStart = true
+		n.currentBlockReceived = false
+		n.startHash = cw.startHash
+		n.endHash = cw.endHash
+		n.ResetStream(n.stream)
+		tidx := 0
+		for tidx < len(n.buffer) {
+			v := n.buffer[tidx]
+			if inShard(v.Hash, n.shardRandomizer, n.startHash, n.endHash) {
+				n.AddHashedSymbolTo(n.stream, v)
+				l := len(n.buffer) - 1
+				n.buffer[tidx] = n.buffer[l]
+				n.buffer = n.buffer[:l]
+			} else {
+				tidx += 1
+			}
+		}
+	}
+	n.AddCodedSymbol(n.stream, cw.CodedSymbol)
+	n.TryDecode()
+	if n.Decoded(n.stream) {
+		n.currentBlockReceived = true
+		ack.ackBlock = true
+		for _, tx := range n.Local(n.stream) {
+			ack.txs = append(ack.txs, tx)
+		}
+		n.outbox = append(n.outbox, ack)
+		res := []riblt.HashedSymbol[transaction]{}
+		for _, v := range n.Remote(n.stream) {
+			res = append(res, v)
+		}
+		n.ResetStream(n.stream)
+		return res, true
+	} else {
+		n.outbox = append(n.outbox, ack)
+		return nil, false
+	}
+}
+
+func (n *multiReceiver) onTransaction(tx riblt.HashedSymbol[transaction]) {
+	n.buffer = append(n.buffer, tx)
+}
@@ -4,28 +4,9 @@ import (
 	"encoding/binary"
 	"github.com/DataDog/sketches-go/ddsketch"
 	"github.com/dchest/siphash"
-	"math"
 	"time"
 )
 
-type degseq struct {
-	count int
-}
-
-func (t *degseq) Reset() {
-	t.count = 0
-}
-
-func (t *degseq) NextThreshold() uint64 {
-	var th uint64
-	th = math.MaxUint64
-	if t.count != 0 {
-		th = uint64(float64(th) / (1 + float64(t.count)/2))
-	}
-	t.count += 1
-	return th
-}
-
 type transaction struct {
 	idx uint64
 	ts  time.Duration
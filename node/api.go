@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The local API of the node is served over HTTP, on a TCP address or on a
+// Unix socket:
+//
+//	POST /transactions
+//		submits transactions to relay, as a JSON object
+//		{"transactions": ["hex", ...]}, where each transaction is 8 to
+//		ldpc.MaxTxSize bytes, or exactly ldpc.TxSize bytes under the
+//		block protocol. It responds with {"accepted": n}.
+//	GET /transactions/stream
+//		streams the transactions the node decodes from then on, one JSON
+//		object per line: {"tx": "hex", "peer": "...", "latency_ms": 1.2}.
+//		A subscriber that falls behind by subscriberBuffer transactions is
+//		disconnected.
+//
+// The first 8 bytes of a transaction are, by convention, the time it was
+// created in Unix microseconds, little endian; nodes measure latency with
+// them.
+
+const (
+	subscriberBuffer = 4096
+	maxSubmitSize    = 16 << 20
+)
+
+var errSlowSubscriber = errors.New("subscriber fell behind")
+
+// decodedEvent is a transaction that the node decoded.
+type decodedEvent struct {
+	Tx        string  `json:"tx"`
+	Peer      string  `json:"peer"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+type subscriber struct {
+	ch chan decodedEvent
+}
+
+// hub delivers decoded transactions to subscribers. The zero value has no
+// subscribers.
+type hub struct {
+	lock sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+func (h *hub) subscribe() *subscriber {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	s := &subscriber{make(chan decodedEvent, subscriberBuffer)}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes s, and closes its channel unless publish did.
+func (h *hub) unsubscribe(s *subscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, there := h.subs[s]; there {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// publish delivers e to every subscriber without blocking. It closes the
+// channel of a subscriber that is full, which then has missed e.
+func (h *hub) publish(e decodedEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for s := range h.subs {
+		select {
+		case s.ch <- e:
+		default:
+			delete(h.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// submit queues tx to be relayed to all peers, waiting until there is room
+// or ctx is done.
+func (c *controller) submit(ctx context.Context, tx *ldpc.Transaction) error {
+	select {
+	case c.localTransaction <- tx:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkTransactionSize returns an error if transactions of size bytes cannot
+// be relayed. They must hold the time they were created, and the block
+// protocol only relays transactions of ldpc.TxSize bytes.
+func (c *controller) checkTransactionSize(size int) error {
+	switch {
+	case c.block != nil && size != ldpc.TxSize:
+		return fmt.Errorf("%d bytes, expected %d", size, ldpc.TxSize)
+	case size < minTxSize || size > ldpc.MaxTxSize:
+		return fmt.Errorf("%d bytes, expected %d to %d", size, minTxSize, ldpc.MaxTxSize)
+	}
+	return nil
+}
+
+// publishDecoded delivers a transaction decoded from peer to subscribers.
+func (c *controller) publishDecoded(dt []byte, peer string) {
+	c.subscribers.publish(decodedEvent{
+		Tx:        hex.EncodeToString(dt),
+		Peer:      peer,
+		LatencyMs: getDelayUs(dt) / 1000.0,
+	})
+}
+
+type submitRequest struct {
+	Transactions []string `json:"transactions"`
+}
+
+type submitResponse struct {
+	Accepted int `json:"accepted"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newAPIHandler returns the handler of the local API of c.
+func newAPIHandler(c *controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"use POST to submit transactions"})
+			return
+		}
+		req := submitRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubmitSize)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		// check all of them before relaying any
+		txs := make([]*ldpc.Transaction, len(req.Transactions))
+		for i, s := range req.Transactions {
+			data, err := hex.DecodeString(s)
+			if err == nil {
+				err = c.checkTransactionSize(len(data))
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("transaction %d: %v", i, err)})
+				return
+			}
+			txs[i] = &ldpc.Transaction{}
+			txs[i].UnmarshalBinary(data)
+		}
+		for i, tx := range txs {
+			if err := c.submit(r.Context(), tx); err != nil {
+				writeJSON(w, http.StatusServiceUnavailable, submitResponse{i})
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, submitResponse{len(txs)})
+	})
+	mux.HandleFunc("/transactions/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"use GET to subscribe"})
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, apiError{"streaming not supported"})
+			return
+		}
+		s := c.subscribers.subscribe()
+		defer c.subscribers.unsubscribe(s)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case e, ok := <-s.ch:
+				if !ok {
+					log.Printf("api subscriber %s: %v\n", r.RemoteAddr, errSlowSubscriber)
+					return
+				}
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+				// let the events that are already waiting go together
+				if len(s.ch) == 0 {
+					flusher.Flush()
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	return mux
+}
+
+// listenAPI listens at addr, which is a TCP address, or the path of a Unix
+// socket prefixed with "unix:".
+func listenAPI(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// remove the socket left by an earlier run
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamEvents decodes the events in the stream r of a subscription.
+func streamEvents(t *testing.T, r io.Reader) <-chan decodedEvent {
+	events := make(chan decodedEvent)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 4*ldpc.MaxTxSize)
+		for sc.Scan() {
+			e := decodedEvent{}
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Error(err)
+				return
+			}
+			events <- e
+		}
+	}()
+	return events
+}
+
+func postTransactions(t *testing.T, url string, txs []string) (int, submitResponse) {
+	t.Helper()
+	body, _ := json.Marshal(submitRequest{txs})
+	resp, err := http.Post(url+"/transactions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	res := submitResponse{}
+	json.NewDecoder(resp.Body).Decode(&res)
+	return resp.StatusCode, res
+}
+
+// TestAPI submits transactions to one node over its API, and receives them
+// from the stream of another.
+func TestAPI(t *testing.T) {
+	config := &blockConfig{
+		controlOverhead: 0.1,
+		numShards:       4,
+		blockInterval:   time.Millisecond,
+	}
+	a := startClusterNode(t, "127.0.0.1:0", config)
+	b := startClusterNode(t, "127.0.0.1:0", config)
+	a.connect(b.addr)
+	waitFor(t, "peers to connect", func() bool { return b.peerCount.Load() == 1 })
+	apiA := httptest.NewServer(newAPIHandler(a.controller))
+	defer apiA.Close()
+	apiB := httptest.NewServer(newAPIHandler(b.controller))
+	defer apiB.Close()
+
+	resp, err := http.Get(apiB.URL + "/transactions/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("subscription failed with status", resp.StatusCode)
+	}
+
+	const n = 20
+	sent := map[string]struct{}{}
+	txs := []string{}
+	for i := 0; i < n; i++ {
+		tx := hex.EncodeToString(randomTransaction().Serialized())
+		sent[tx] = struct{}{}
+		txs = append(txs, tx)
+	}
+	if status, res := postTransactions(t, apiA.URL, txs); status != http.StatusOK || res.Accepted != n {
+		t.Fatalf("submission failed with status %d, %d accepted", status, res.Accepted)
+	}
+
+	events := streamEvents(t, resp.Body)
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			if _, there := sent[e.Tx]; !there {
+				t.Fatal("received a transaction never sent")
+			}
+			delete(sent, e.Tx)
+			if e.Peer == "" || e.LatencyMs < 0 {
+				t.Errorf("bad source peer %q or latency %f", e.Peer, e.LatencyMs)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("received %d of %d transactions", i, n)
+		}
+	}
+}
+
+// TestAPIVariableLength relays transactions of various lengths with the
+// ldpc protocol, and checks that they arrive trimmed to their lengths.
+func TestAPIVariableLength(t *testing.T) {
+	a := startClusterNode(t, "127.0.0.1:0", nil)
+	b := startClusterNode(t, "127.0.0.1:0", nil)
+	a.connect(b.addr)
+	waitFor(t, "peers to connect", func() bool { return b.peerCount.Load() == 1 })
+	apiA := httptest.NewServer(newAPIHandler(a.controller))
+	defer apiA.Close()
+	apiB := httptest.NewServer(newAPIHandler(b.controller))
+	defer apiB.Close()
+
+	resp, err := http.Get(apiB.URL + "/transactions/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := streamEvents(t, resp.Body)
+
+	const n = 50
+	sent := map[string]struct{}{}
+	txs := []string{}
+	for i := 0; i < n; i++ {
+		d := make([]byte, 8+rand.Intn(4096))
+		binary.LittleEndian.PutUint64(d, uint64(time.Now().UnixMicro()))
+		rand.Read(d[8:])
+		tx := hex.EncodeToString(d)
+		sent[tx] = struct{}{}
+		txs = append(txs, tx)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, tx := range txs {
+			postTransactions(t, apiA.URL, []string{tx})
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	// ldpc decodes most, not necessarily all, transactions
+	for i := 0; i < n*9/10; i++ {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			if _, there := sent[e.Tx]; !there {
+				t.Fatal("received a transaction never sent")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("received %d of %d transactions", i, n)
+		}
+	}
+	<-done
+}
+
+// TestShortTransaction relays a transaction too short to hold the time it
+// was created, which our API refuses but a peer may encode anyway, and checks
+// that the receiving node drops it and keeps going.
+func TestShortTransaction(t *testing.T) {
+	a := startClusterNode(t, "127.0.0.1:0", nil)
+	b := startClusterNode(t, "127.0.0.1:0", nil)
+	a.connect(b.addr)
+	waitFor(t, "peers to connect", func() bool { return b.peerCount.Load() == 1 })
+	apiB := httptest.NewServer(newAPIHandler(b.controller))
+	defer apiB.Close()
+	resp, err := http.Get(apiB.URL + "/transactions/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := streamEvents(t, resp.Body)
+
+	short := &ldpc.Transaction{}
+	short.UnmarshalBinary([]byte{1, 2, 3})
+	a.localTransaction <- short
+	const n = 20
+	a.submit(n)
+	for i := 0; i < n*9/10; i++ {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			if len(e.Tx) < 2*minTxSize {
+				t.Fatal("received a transaction shorter than", minTxSize, "bytes")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("received %d of %d transactions", i, n)
+		}
+	}
+}
+
+func TestAPIBadRequests(t *testing.T) {
+	c := &controller{}
+	api := httptest.NewServer(newAPIHandler(c))
+	defer api.Close()
+	cases := map[string][]string{
+		"short":  {"00ff"},
+		"nonhex": {strings.Repeat("zz", 128)},
+		// valid ones are not relayed when others are not
+		"mixed": {strings.Repeat("00", 128), "00"},
+		"long":  {strings.Repeat("00", ldpc.MaxTxSize+1)},
+	}
+	for name, txs := range cases {
+		if status, _ := postTransactions(t, api.URL, txs); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d", name, status)
+		}
+	}
+	blockAPI := httptest.NewServer(newAPIHandler(&controller{block: &blockConfig{}}))
+	defer blockAPI.Close()
+	if status, _ := postTransactions(t, blockAPI.URL, []string{strings.Repeat("00", 100)}); status != http.StatusBadRequest {
+		t.Errorf("block protocol, short: status %d", status)
+	}
+	resp, err := http.Post(api.URL+"/transactions", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed json: status %d", resp.StatusCode)
+	}
+	resp, err = http.Get(api.URL + "/transactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET to submit: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/dchest/siphash"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// The block protocol is the sharded riblt protocol of newsim/coding.go. The
+// peer that dialed a connection is the sender: it splits the hash space
+// into shards, and reconciles them with the peer one block at a time,
+// encoding its new transactions of the shard into coded symbols. The peer
+// that accepted the connection is the receiver: it decodes each block
+// against its own new transactions of the shard, and acknowledges every
+// coded symbol. The ack that finishes a block carries the transactions that
+// the receiver has and the sender does not. The sender keeps a window of
+// coded symbols in flight, which grows by controlOverhead with every ack,
+// and starts the next block with a window of controlOverhead times the
+// number of coded symbols the last one took.
+
+// blockTransaction is a transaction as a symbol of riblt.
+type blockTransaction [ldpc.TxSize]byte
+
+func (t blockTransaction) XOR(t2 blockTransaction) blockTransaction {
+	for i := 0; i < ldpc.TxSize; i += 8 {
+		binary.LittleEndian.PutUint64(t[i:], binary.LittleEndian.Uint64(t[i:])^binary.LittleEndian.Uint64(t2[i:]))
+	}
+	return t
+}
+
+func (t blockTransaction) Hash() uint64 {
+	return siphash.Hash(567, 890, t[:])
+}
+
+func hashedBlockTransaction(t blockTransaction) riblt.HashedSymbol[blockTransaction] {
+	return riblt.HashedSymbol[blockTransaction]{Symbol: t, Hash: t.Hash()}
+}
+
+// blockTransactionCodec serializes a blockTransaction as its bytes.
+type blockTransactionCodec struct{}
+
+func (blockTransactionCodec) AppendSymbol(buf []byte, t blockTransaction) []byte {
+	return append(buf, t[:]...)
+}
+
+func (blockTransactionCodec) ReadSymbol(r riblt.ByteReader) (blockTransaction, error) {
+	var t blockTransaction
+	_, err := io.ReadFull(r, t[:])
+	return t, err
+}
+
+// blockSymbol is a coded symbol of the current block. The first one of a
+// block starts it, and carries the shard of the hash space it covers.
+type blockSymbol struct {
+	riblt.CodedSymbol[blockTransaction]
+	newBlock  bool
+	startHash uint64
+	endHash   uint64
+}
+
+// blockAck acknowledges a coded symbol.
+type blockAck struct {
+	ackStart bool // first ack of the block
+	ackBlock bool // the block is decoded
+	txs      []riblt.HashedSymbol[blockTransaction]
+}
+
+// blockTransactions are transactions of a finishing ack that did not fit in
+// its frame, and are sent ahead of it.
+type blockTransactions []riblt.HashedSymbol[blockTransaction]
+
+// blockConfig is the configuration of the block protocol.
+type blockConfig struct {
+	controlOverhead float64
+	numShards       int
+	// minimum time between the starts of two blocks, so that idle peers do
+	// not reconcile empty shards as fast as the link allows
+	blockInterval time.Duration
+}
+
+// inShard returns whether a transaction of hash h is in the shard from
+// startHash to endHash, which wraps around if endHash is not larger.
+func inShard(h, randomizer, startHash, endHash uint64) bool {
+	sh := h * randomizer
+	if startHash < endHash {
+		return sh >= startHash && sh < endHash
+	}
+	return sh >= startHash || sh < endHash
+}
+
+// takeShard moves the transactions of buf in the shard to add, and returns
+// the rest.
+func takeShard(buf []riblt.HashedSymbol[blockTransaction], randomizer, startHash, endHash uint64, add func(riblt.HashedSymbol[blockTransaction])) []riblt.HashedSymbol[blockTransaction] {
+	tidx := 0
+	for tidx < len(buf) {
+		v := buf[tidx]
+		if inShard(v.Hash, randomizer, startHash, endHash) {
+			add(v)
+			l := len(buf) - 1
+			buf[tidx] = buf[l]
+			buf = buf[:l]
+		} else {
+			tidx += 1
+		}
+	}
+	return buf
+}
+
+// shardRandomizer derives the multiplier that maps hashes to shards from
+// the key of the session, so that the shards differ across sessions. It is
+// odd, which makes the mapping a permutation.
+func shardRandomizer(key [ldpc.SaltSize]byte) uint64 {
+	return binary.LittleEndian.Uint64(key[0:8]) | 1
+}
+
+type blockSender struct {
+	encoder *riblt.Encoder[blockTransaction]
+	buffer  []riblt.HashedSymbol[blockTransaction]
+	blockConfig
+
+	// send window
+	sendWindow            float64
+	inFlight              int
+	encodingCurrentBlock  bool
+	currentBlockAckCount  int
+	receivingCurrentBlock bool
+	lastBlockStart        time.Time
+
+	shardSchedule   []int
+	shardRandomizer uint64
+	nextShard       int
+}
+
+func newBlockSender(config blockConfig, key [ldpc.SaltSize]byte) *blockSender {
+	s := &blockSender{
+		encoder:         &riblt.Encoder[blockTransaction]{},
+		blockConfig:     config,
+		sendWindow:      1,
+		shardSchedule:   rand.Perm(config.numShards),
+		shardRandomizer: shardRandomizer(key),
+	}
+	s.encoder.SetKey(riblt.NewKey(key))
+	return s
+}
+
+// onAck takes an ack from the receiver, and returns the transactions it
+// carries.
+func (n *blockSender) onAck(ack blockAck) []riblt.HashedSymbol[blockTransaction] {
+	if ack.ackBlock {
+		n.encodingCurrentBlock = false
+		n.receivingCurrentBlock = false
+	}
+	if ack.ackStart {
+		n.currentBlockAckCount = 0
+		n.receivingCurrentBlock = true
+	}
+	if n.receivingCurrentBlock {
+		n.currentBlockAckCount += 1
+		n.inFlight -= 1
+		n.sendWindow += n.controlOverhead
+		if n.sendWindow < 1 {
+			n.sendWindow = 1
+		}
+	}
+	return ack.txs
+}
+
+func (n *blockSender) onTransaction(tx riblt.HashedSymbol[blockTransaction]) {
+	n.buffer = append(n.buffer, tx)
+}
+
+// fillSendWindow produces coded symbols into out until the window is full.
+func (n *blockSender) fillSendWindow(out func(blockSymbol)) {
+	for {
+		cw, yes, burst := n.tryProduceCodeword()
+		if !yes {
+			return
+		}
+		out(cw)
+		n.inFlight += 1
+		for i := 0; i < burst; i++ {
+			out(blockSymbol{CodedSymbol: n.encoder.ProduceNextCodedSymbol()})
+			n.inFlight += 1
+		}
+	}
+}
+
+func (n *blockSender) tryProduceCodeword() (blockSymbol, bool, int) {
+	cw := blockSymbol{}
+	burstSize := 0
+	if !n.encodingCurrentBlock {
+		if time.Since(n.lastBlockStart) < n.blockInterval {
+			return cw, false, 0
+		}
+		// start the next block even if we have nothing of its shard, as
+		// the receiver may have some
+		n.lastBlockStart = time.Now()
+		cw.newBlock = true
+		shardSize := ((1 << 64) - 1) / uint64(len(n.shardSchedule))
+		cw.startHash = uint64(n.shardSchedule[n.nextShard]) * shardSize
+		cw.endHash = uint64((n.shardSchedule[n.nextShard]+1)%len(n.shardSchedule)) * shardSize
+		n.encoder.Reset()
+		n.buffer = takeShard(n.buffer, n.shardRandomizer, cw.startHash, cw.endHash, n.encoder.AddHashedSymbol)
+		burstSize = n.currentBlockAckCount * 2 / 3
+		n.nextShard = (n.nextShard + 1) % len(n.shardSchedule)
+		n.encodingCurrentBlock = true
+		n.sendWindow = float64(n.currentBlockAckCount) * n.controlOverhead
+		if n.sendWindow < 1 {
+			n.sendWindow = 1
+		}
+		n.currentBlockAckCount = 0
+		n.inFlight = 0
+	}
+	if float64(n.inFlight) < n.sendWindow {
+		cw.CodedSymbol = n.encoder.ProduceNextCodedSymbol()
+		return cw, true, burstSize
+	} else {
+		return cw, false, burstSize
+	}
+}
+
+type blockReceiver struct {
+	decoder *riblt.Decoder[blockTransaction]
+	buffer  []riblt.HashedSymbol[blockTransaction]
+
+	currentBlockReceived bool
+	shardRandomizer      uint64
+}
+
+func newBlockReceiver(key [ldpc.SaltSize]byte) *blockReceiver {
+	r := &blockReceiver{
+		decoder:         &riblt.Decoder[blockTransaction]{},
+		shardRandomizer: shardRandomizer(key),
+	}
+	r.decoder.SetKey(riblt.NewKey(key))
+	return r
+}
+
+// onCodeword takes a coded symbol from the sender. It returns the ack to
+// send back, unless the symbol is of a block that is already decoded, and
+// the transactions decoded.
+func (n *blockReceiver) onCodeword(cw blockSymbol) (*blockAck, []riblt.HashedSymbol[blockTransaction], error) {
+	if n.currentBlockReceived && !cw.newBlock {
+		return nil, nil, nil
+	}
+	ack := &blockAck{}
+	if cw.newBlock {
+		ack.ackStart = true
+		n.currentBlockReceived = false
+		n.decoder.Reset()
+		n.buffer = takeShard(n.buffer, n.shardRandomizer, cw.startHash, cw.endHash, n.decoder.AddHashedSymbol)
+	}
+	n.decoder.AddCodedSymbol(cw.CodedSymbol)
+	if err := n.decoder.TryDecode(); err != nil {
+		return nil, nil, err
+	}
+	if !n.decoder.Decoded() {
+		return ack, nil, nil
+	}
+	n.currentBlockReceived = true
+	ack.ackBlock = true
+	ack.txs = append(ack.txs, n.decoder.Local()...)
+	res := append([]riblt.HashedSymbol[blockTransaction]{}, n.decoder.Remote()...)
+	n.decoder.Reset()
+	return ack, res, nil
+}
+
+func (n *blockReceiver) onTransaction(tx riblt.HashedSymbol[blockTransaction]) {
+	n.buffer = append(n.buffer, tx)
+}
+
+// receivedTransaction is a transaction that a peer sent us.
+type receivedTransaction struct {
+	riblt.HashedSymbol[blockTransaction]
+	from *peer
+}
+
+// newBlockPeer starts running the block protocol with the peer over t, as
+// the sender if dialed and as the receiver otherwise, and sends the peer to
+// gone once it is disconnected.
+func newBlockPeer(id string, t *streamTransport, dialed bool, decoded chan<- receivedTransaction, gone chan<- *peer, config blockConfig, encoderKey [ldpc.SaltSize]byte, decoderKey [ldpc.SaltSize]byte) *peer {
+	newTx := make(chan riblt.HashedSymbol[blockTransaction], 100)
+	p := &peer{
+		id:         id,
+		newBlockTx: newTx,
+		transport:  t,
+		done:       make(chan struct{}),
+		metrics:    &peerMetrics{},
+	}
+	b := &blockPeer{
+		peer:    p,
+		t:       t,
+		newTx:   newTx,
+		decoded: decoded,
+		config:  config,
+	}
+	if dialed {
+		b.sender = newBlockSender(config, encoderKey)
+	} else {
+		b.receiver = newBlockReceiver(decoderKey)
+	}
+
+	outbox := make(chan any, 1000)
+	inbox := make(chan any, 1000)
+	go b.loop(inbox, outbox)
+	go func() {
+		err := b.send(outbox)
+		if err != nil {
+			p.stop(fmt.Errorf("error sending block messages: %w", err))
+		}
+	}()
+	go func() {
+		err := b.receive(inbox)
+		if err == io.EOF {
+			p.stop(errConnectionClosed)
+		} else if err != nil {
+			p.stop(fmt.Errorf("error receiving block messages: %w", err))
+		}
+	}()
+	go func() {
+		<-p.done
+		gone <- p
+	}()
+	return p
+}
+
+// blockPeer runs one side of the block protocol with a peer.
+type blockPeer struct {
+	*peer
+	t        *streamTransport
+	sender   *blockSender   // nil unless we dialed
+	receiver *blockReceiver // nil if we dialed
+	newTx    <-chan riblt.HashedSymbol[blockTransaction]
+	decoded  chan<- receivedTransaction
+	config   blockConfig
+}
+
+func (b *blockPeer) send(ch <-chan any) error {
+	for m := range ch {
+		err := b.t.writeBlockMessage(m)
+		if err != nil {
+			return err
+		}
+		// let the transport batch messages that are already waiting
+		if len(ch) == 0 {
+			err = b.t.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *blockPeer) receive(ch chan<- any) error {
+	for {
+		m, err := b.t.readBlockMessage()
+		if err != nil {
+			return err
+		}
+		select {
+		case ch <- m:
+		case <-b.done:
+			return nil
+		}
+	}
+}
+
+// loop runs the protocol until the peer is disconnected, and then closes
+// outbox. Messages to the peer and transactions to the controller are
+// queued here rather than blocking, so that the loop keeps taking messages
+// from the peer and the two sides cannot wait on each other.
+func (b *blockPeer) loop(inbox <-chan any, outbox chan<- any) {
+	defer close(outbox)
+	var tick <-chan time.Time
+	if b.sender != nil && b.config.blockInterval > 0 {
+		ticker := time.NewTicker(b.config.blockInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var pendingOut []any
+	var pendingDecoded []receivedTransaction
+	out := func(cw blockSymbol) {
+		pendingOut = append(pendingOut, cw)
+		b.metrics.codewordsSent.Add(1)
+	}
+	received := func(txs []riblt.HashedSymbol[blockTransaction]) {
+		b.metrics.decoded.Add(uint64(len(txs)))
+		for _, tx := range txs {
+			pendingDecoded = append(pendingDecoded, receivedTransaction{tx, b.peer})
+		}
+	}
+	if b.sender != nil {
+		b.sender.fillSendWindow(out)
+	}
+	for {
+		var outCh chan<- any
+		var nextOut any
+		if len(pendingOut) > 0 {
+			outCh = outbox
+			nextOut = pendingOut[0]
+		}
+		var decodedCh chan<- receivedTransaction
+		var nextDecoded receivedTransaction
+		if len(pendingDecoded) > 0 {
+			decodedCh = b.decoded
+			nextDecoded = pendingDecoded[0]
+		}
+		select {
+		case <-b.done:
+			return
+		case outCh <- nextOut:
+			pendingOut[0] = nil
+			pendingOut = pendingOut[1:]
+		case decodedCh <- nextDecoded:
+			pendingDecoded = pendingDecoded[1:]
+		case tx := <-b.newTx:
+			if b.sender != nil {
+				b.sender.onTransaction(tx)
+				b.sender.fillSendWindow(out)
+			} else {
+				b.receiver.onTransaction(tx)
+			}
+		case m := <-inbox:
+			switch m := m.(type) {
+			case blockSymbol:
+				if b.receiver == nil {
+					b.stop(errUnexpectedMessage)
+					return
+				}
+				b.metrics.codewordsReceived.Add(1)
+				ack, txs, err := b.receiver.onCodeword(m)
+				if err != nil {
+					b.stop(fmt.Errorf("error decoding block: %w", err))
+					return
+				}
+				if ack != nil {
+					pendingOut = append(pendingOut, *ack)
+				}
+				received(txs)
+			case blockAck:
+				if b.sender == nil {
+					b.stop(errUnexpectedMessage)
+					return
+				}
+				received(b.sender.onAck(m))
+				b.sender.fillSendWindow(out)
+				b.metrics.pending.Store(int64(b.sender.inFlight))
+			case blockTransactions:
+				if b.sender == nil {
+					b.stop(errUnexpectedMessage)
+					return
+				}
+				received(m)
+			}
+		case <-tick:
+			// start the next block if it was held back
+			b.sender.fillSendWindow(out)
+		}
+	}
+}
+
+// recentSet remembers the hashes added to it for at least retention.
+type recentSet struct {
+	cur, prev map[uint64]struct{}
+	rotated   time.Time
+	retention time.Duration
+}
+
+func newRecentSet(retention time.Duration) *recentSet {
+	return &recentSet{
+		cur:       make(map[uint64]struct{}),
+		prev:      make(map[uint64]struct{}),
+		rotated:   time.Now(),
+		retention: retention,
+	}
+}
+
+// add adds h, and returns false if it is already there.
+func (s *recentSet) add(h uint64) bool {
+	if time.Since(s.rotated) > s.retention {
+		s.prev = s.cur
+		s.cur = make(map[uint64]struct{})
+		s.rotated = time.Now()
+	}
+	if _, there := s.cur[h]; there {
+		return false
+	}
+	if _, there := s.prev[h]; there {
+		return false
+	}
+	s.cur[h] = struct{}{}
+	return true
+}
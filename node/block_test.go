@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+	"net"
+	"testing"
+	"time"
+)
+
+func testBlockTransaction(i int) riblt.HashedSymbol[blockTransaction] {
+	var t blockTransaction
+	t[0] = byte(i)
+	t[1] = byte(i >> 8)
+	t[ldpc.TxSize-1] = byte(i >> 16)
+	return hashedBlockTransaction(t)
+}
+
+func TestBlockMessages(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	res := make(chan *streamTransport)
+	go func() {
+		ta, _, err := newStreamTransport(a, blockStreamMagic, [ldpc.SaltSize]byte{})
+		if err != nil {
+			t.Error(err)
+		}
+		res <- ta
+	}()
+	tb, _, err := newStreamTransport(b, blockStreamMagic, [ldpc.SaltSize]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := <-res
+	if ta == nil {
+		t.FailNow()
+	}
+
+	enc := riblt.Encoder[blockTransaction]{}
+	for i := 0; i < 10; i++ {
+		enc.AddHashedSymbol(testBlockTransaction(i))
+	}
+	sym := blockSymbol{enc.ProduceNextCodedSymbol(), true, 100, 200}
+	// more transactions than fit in a frame
+	ack := blockAck{ackBlock: true}
+	for i := 0; i < maxBlockTransactions+10; i++ {
+		ack.txs = append(ack.txs, testBlockTransaction(i))
+	}
+	go func() {
+		ta.writeBlockMessage(sym)
+		ta.writeBlockMessage(ack)
+		ta.flush()
+	}()
+
+	m, err := tb.readBlockMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != any(sym) {
+		t.Error("coded symbol corrupted")
+	}
+	var txs []riblt.HashedSymbol[blockTransaction]
+	for {
+		m, err := tb.readBlockMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if more, ok := m.(blockTransactions); ok {
+			txs = append(txs, more...)
+			continue
+		}
+		last := m.(blockAck)
+		if last.ackStart || !last.ackBlock {
+			t.Error("ack flags corrupted")
+		}
+		txs = append(txs, last.txs...)
+		break
+	}
+	if len(txs) != len(ack.txs) {
+		t.Fatalf("received %d transactions, sent %d", len(txs), len(ack.txs))
+	}
+	for i := range txs {
+		if txs[i] != ack.txs[i] {
+			t.Fatalf("transaction %d corrupted", i)
+		}
+	}
+}
+
+func TestBlockHandshakeMismatch(t *testing.T) {
+	ca, cb := tcpPair(t)
+	errc := make(chan error)
+	go func() {
+		_, _, err := newStreamTransport(cb, streamMagic, [ldpc.SaltSize]byte{})
+		errc <- err
+	}()
+	_, _, err := newStreamTransport(ca, blockStreamMagic, [ldpc.SaltSize]byte{})
+	if err != errCodingMismatch {
+		t.Errorf("expected coding mismatch, got %v", err)
+	}
+	if err := <-errc; err != errCodingMismatch {
+		t.Errorf("expected coding mismatch, got %v", err)
+	}
+}
+
+// TestBlockProtocol runs the block protocol on a line of three nodes, where
+// the middle one is the receiver of both links, and checks that every node
+// gets every transaction exactly once.
+func TestBlockProtocol(t *testing.T) {
+	config := &blockConfig{
+		controlOverhead: 0.1,
+		numShards:       16,
+		blockInterval:   time.Millisecond,
+	}
+	a := startClusterNode(t, "127.0.0.1:0", config)
+	b := startClusterNode(t, "127.0.0.1:0", config)
+	c := startClusterNode(t, "127.0.0.1:0", config)
+	a.connect(b.addr)
+	c.connect(b.addr)
+	waitFor(t, "peers to connect", func() bool { return b.peerCount.Load() == 2 })
+
+	const batch = 300
+	done := make(chan struct{})
+	go func() {
+		a.submit(batch)
+		close(done)
+	}()
+	c.submit(batch)
+	<-done
+	waitFor(t, "transactions to propagate", func() bool {
+		return a.decodedCount.Load() >= batch && b.decodedCount.Load() >= 2*batch && c.decodedCount.Load() >= batch
+	})
+	// let duplicates arrive, if any
+	time.Sleep(200 * time.Millisecond)
+	if a.decodedCount.Load() != batch || b.decodedCount.Load() != 2*batch || c.decodedCount.Load() != batch {
+		t.Errorf("decoded %d, %d and %d transactions", a.decodedCount.Load(), b.decodedCount.Load(), c.decodedCount.Load())
+	}
+}
@@ -1,40 +1,94 @@
 package main
 
 import (
-	"encoding/gob"
+	"errors"
+	"fmt"
 	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
 	"github.com/yangl1996/soliton"
 	"io"
 	"log"
+	"net"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/DataDog/sketches-go/ddsketch"
 )
 
+var errConnectionClosed = errors.New("connection closed by peer")
+
 type peer struct {
+	id string
 	newTxToSender chan<- *ldpc.Transaction
 	newTxToReceiver chan<- *ldpc.Transaction
+	newBlockTx chan<- riblt.HashedSymbol[blockTransaction] // block protocol only
+	transport codewordTransport
+	metrics *peerMetrics
+
+	// done is closed when the peer is disconnected, and err is the reason
+	done chan struct{}
+	stopOnce sync.Once
+	err error
 }
 
 func (h *peer) notifyUnexpiredTransaction (t *ldpc.Transaction) {
-	h.newTxToSender <- t
-	h.newTxToReceiver <- t 
+	select {
+	case h.newTxToSender <- t:
+	case <-h.done:
+		return
+	}
+	select {
+	case h.newTxToReceiver <- t:
+	case <-h.done:
+	}
 }
 
 func (h *peer) notifyExpiredTransaction(t *ldpc.Transaction) {
-	h.newTxToReceiver <- t 
+	select {
+	case h.newTxToReceiver <- t:
+	case <-h.done:
+	}
 }
 
-func newPeer(id string, conn io.ReadWriter, decoded chan<- ldpc.DecodedTransaction, importTx []*ldpc.Transaction, K, M uint64, solitonC, solitonDelta, initRate, minRate, incConstant, targetLoss float64, decodeTimeout time.Duration, encoderKey [ldpc.SaltSize]byte, decoderKey [ldpc.SaltSize]byte) *peer {
+func (h *peer) notifyBlockTransaction(t riblt.HashedSymbol[blockTransaction]) {
+	select {
+	case h.newBlockTx <- t:
+	case <-h.done:
+	}
+}
+
+// stop disconnects the peer for reason err and stops its goroutines. Only
+// the first reason is kept.
+func (h *peer) stop(err error) {
+	h.stopOnce.Do(func() {
+		h.err = err
+		h.transport.Close()
+		close(h.done)
+	})
+}
+
+// newPeer starts exchanging codewords with the peer over t, and sends the
+// peer to gone once it is disconnected.
+func newPeer(id string, t codewordTransport, decoded chan<- peerDecodedTransaction, gone chan<- *peer, importTx []*ldpc.Transaction, K, M uint64, solitonC, solitonDelta, initRate, minRate, incConstant, targetLoss float64, decodeTimeout time.Duration, encoderKey [ldpc.SaltSize]byte, decoderKey [ldpc.SaltSize]byte) *peer {
 	peerLoss := make(chan int, 100)
 	ourLoss := make(chan int, 100)
 	senderNewTx := make(chan *ldpc.Transaction, 100)
 	receiverNewTx := make(chan *ldpc.Transaction, 100)
+	p := &peer{
+		id: id,
+		newTxToSender: senderNewTx,
+		newTxToReceiver: receiverNewTx,
+		transport: t,
+		done: make(chan struct{}),
+		metrics: &peerMetrics{},
+	}
+	p.metrics.setRate(initRate)
 
 	dist := soliton.NewRobustSoliton(rand.New(rand.NewSource(time.Now().Unix())), K, solitonC, solitonDelta)
 	s := sender{
 		peerId: id,
-		tx:                   gob.NewEncoder(conn),
+		tx:                   t,
 		encoder:              ldpc.NewEncoder(encoderKey, dist, int(K)),
 		cwRate:               initRate,
 		rateIncreaseConstant: incConstant,
@@ -43,6 +97,8 @@ func newPeer(id string, conn io.ReadWriter, decoded chan<- ldpc.DecodedTransacti
 		peerLoss:             peerLoss,
 		ourLoss: ourLoss,
 		newTransaction:       senderNewTx,
+		done: p.done,
+		metrics: p.metrics,
 	}
 
 	sketch, err := ddsketch.NewDefaultDDSketchWithExactSummaryStatistics(0.001)
@@ -52,7 +108,7 @@ func newPeer(id string, conn io.ReadWriter, decoded chan<- ldpc.DecodedTransacti
 
 	r := receiver{
 		peerId: id,
-		rx:          gob.NewDecoder(conn),
+		rx:          t,
 		decoder:     ldpc.NewDecoder(decoderKey, int(M)),
 		peerLoss: peerLoss,
 		ourLoss: ourLoss,
@@ -60,44 +116,43 @@ func newPeer(id string, conn io.ReadWriter, decoded chan<- ldpc.DecodedTransacti
 		newTransaction: receiverNewTx,
 		timeout:     decodeTimeout,
 		delaySketch: sketch,
+		done: p.done,
+		metrics: p.metrics,
 	}
 	for _, existingTx := range importTx {
 		r.decoder.AddTransaction(existingTx)
 	}
 
 	txCwCh := make(chan Codeword, 1000)
-	go func() {
-		err := s.loop(txCwCh)
-		if err != nil {
-			panic(err)
-		}
-	}()
+	go s.loop(txCwCh)
 	go func() {
 		err := s.sendCodewords(txCwCh)
 		if err != nil {
-			panic(err)
+			p.stop(fmt.Errorf("error sending codewords: %w", err))
 		}
 	}()
 	cwCh := make(chan Codeword, 1000)
 	go func() {
 		err := r.receive(cwCh)
-		if err != nil {
-			panic(err)
+		if err == io.EOF {
+			p.stop(errConnectionClosed)
+		} else if err != nil {
+			p.stop(fmt.Errorf("error receiving codewords: %w", err))
 		}
 	}()
+	go r.decode(cwCh)
 	go func() {
-		err := r.decode(cwCh)
-		if err != nil {
-			panic(err)
-		}
+		<-p.done
+		gone <- p
 	}()
-	return &peer{senderNewTx, receiverNewTx}
+	return p
 }
 
 type controller struct {
 	peers            []*peer
 	newPeer          chan *peer
-	decodedTransaction chan ldpc.DecodedTransaction
+	peerGone         chan *peer
+	decodedTransaction chan peerDecodedTransaction
 	localTransaction chan *ldpc.Transaction
 
 	K uint64
@@ -112,10 +167,27 @@ type controller struct {
 
 	delaySketch *ddsketch.DDSketchWithExactSummaryStatistics
 	warmupTime time.Duration
+
+	identity *identity // nil for plaintext sessions
+
+	block *blockConfig // nil for the ldpc protocol
+	blockDecoded chan receivedTransaction
+
+	subscribers hub // of the local API
+	metrics metricsRegistry
+
+	// read by other goroutines while the loop runs
+	decodedCount atomic.Uint64
+	peerCount    atomic.Int64
 }
 
+// seenRetention is how long the block protocol remembers transactions, and
+// drops them when they arrive again from other peers.
+const seenRetention = 10 * time.Minute
+
 func (c *controller) loop() error {
 	txcnt := 0
+	seen := newRecentSet(seenRetention)
 	start := time.Now()
 	warmupFinished := false
 	ticker := time.NewTicker(1 * time.Second)
@@ -123,11 +195,41 @@ func (c *controller) loop() error {
 	for {
 		select {
 		case tx := <-c.localTransaction:
+			if c.block != nil {
+				btx := hashedBlockTransaction(blockTransaction(tx.Serialized()))
+				seen.add(btx.Hash)
+				for _, peer := range c.peers {
+					peer.notifyBlockTransaction(btx)
+				}
+				break
+			}
 			for _, peer := range c.peers {
 				peer.notifyUnexpiredTransaction(tx)
 			}
+		case tx := <-c.blockDecoded:
+			if !seen.add(tx.Hash) {
+				break
+			}
+			txcnt += 1
+			c.decodedCount.Add(1)
+			for _, peer := range c.peers {
+				if peer != tx.from {
+					peer.notifyBlockTransaction(tx.HashedSymbol)
+				}
+			}
+			c.publishDecoded(tx.Symbol[:], tx.from.id)
+			if warmupFinished {
+				c.recordDelay(tx.Symbol[:])
+			}
 		case tx := <-c.decodedTransaction:
+			// a peer may encode what our API refuses; the transaction
+			// does not hold the time it was created, so drop it
+			if n := len(tx.Transaction.Serialized()); n < minTxSize {
+				log.Printf("peer %s sent a transaction of %d bytes, dropping\n", tx.from, n)
+				break
+			}
 			txcnt += 1
+			c.decodedCount.Add(1)
 			for _, peer := range c.peers {
 				if tx.Expired {
 					peer.notifyExpiredTransaction(tx.Transaction)
@@ -135,16 +237,31 @@ func (c *controller) loop() error {
 					peer.notifyUnexpiredTransaction(tx.Transaction)
 				}
 			}
+			c.publishDecoded(tx.Transaction.Serialized(), tx.from)
 			if warmupFinished {
-				delay := getDelayUs(tx.Transaction)
-				err := c.delaySketch.Add(delay/1000.0)
-				if err != nil {
-					log.Println("error inserting delay into sketch:", err)
-				}
+				c.recordDelay(tx.Transaction.Serialized())
 			}
 		case p := <-c.newPeer:
-			log.Println("new peer")
-			c.peers = append(c.peers, p)
+			select {
+			case <-p.done:
+				// already disconnected, and handled as gone
+				break
+			default:
+				log.Printf("new peer %s\n", p.id)
+				c.peers = append(c.peers, p)
+				c.peerCount.Store(int64(len(c.peers)))
+				c.metrics.addPeer(p)
+			}
+		case p := <-c.peerGone:
+			for i := range c.peers {
+				if c.peers[i] == p {
+					c.peers = append(c.peers[:i], c.peers[i+1:]...)
+					break
+				}
+			}
+			c.peerCount.Store(int64(len(c.peers)))
+			c.metrics.removePeer(p)
+			log.Printf("peer %s disconnected: %v\n", p.id, p.err)
 		case <-ticker.C:
 			log.Printf("total tx %d\n", txcnt)
 			if !warmupFinished {
@@ -155,6 +272,7 @@ func (c *controller) loop() error {
 					break
 				}
 			}
+			c.metrics.setLatency(c.delaySketch.Copy())
 			qts, err := c.delaySketch.GetValuesAtQuantiles([]float64{0.05, 0.50, 0.95})
 			if err != nil {
 				log.Println("error getting quantiles:", err)
@@ -167,24 +285,106 @@ func (c *controller) loop() error {
 	}
 }
 
-func (c *controller) handleConn(id string, conn io.ReadWriter) error {
-	var encoderKey [ldpc.SaltSize]byte
-	var decoderKey [ldpc.SaltSize]byte
-	rand.Read(encoderKey[:])
-
-	// send our key
-	_, err := conn.Write(encoderKey[:])
+// recordDelay records the delay of the serialized transaction dt, which we
+// just received.
+func (c *controller) recordDelay(dt []byte) {
+	delay := getDelayUs(dt)
+	err := c.delaySketch.Add(delay/1000.0)
 	if err != nil {
-		return err
+		log.Println("error inserting delay into sketch:", err)
 	}
-	_, err = conn.Read(decoderKey[:])
+}
+
+// handleConn exchanges keys with the peer over conn, which we dialed if
+// dialed is set, and starts exchanging codewords. It closes conn if the
+// handshake fails.
+func (c *controller) handleConn(id string, conn net.Conn, dialed bool) (*peer, error) {
+	t, encoderKey, decoderKey, err := c.handshake(id, conn, dialed)
 	if err != nil {
-		return err
+		conn.Close()
+		log.Printf("handshake with peer %s failed: %v\n", id, err)
+		return nil, err
+	}
+
+	var p *peer
+	if c.block != nil {
+		p = newBlockPeer(id, t, dialed, c.blockDecoded, c.peerGone, *c.block, encoderKey, decoderKey)
+	} else {
+		p = newPeer(id, t, c.decodedTransaction, c.peerGone, nil, c.K, c.M, c.solitonC, c.solitonDelta, c.initRate, c.minRate, c.incConstant, c.targetLoss, c.decodeTimeout, encoderKey, decoderKey)
+	}
+
+	c.newPeer <- p
+	return p, nil
+}
+
+const (
+	minRedialBackoff = 1 * time.Second
+	maxRedialBackoff = 30 * time.Second
+)
+
+// maintainOutbound keeps a session with the peer at addr, dialing it with
+// dial, and dialing again with exponential backoff whenever the connection
+// fails or the session ends. Each session starts with a fresh encoder and
+// decoder, since the salts change. It never returns.
+func (c *controller) maintainOutbound(addr string, dial func(addr string) (net.Conn, error)) {
+	backoff := minRedialBackoff
+	for {
+		cn, err := dial(addr)
+		if err != nil {
+			log.Printf("error connecting to peer %s: %v\n", addr, err)
+		} else if p, err := c.handleConn(addr, cn, true); err == nil {
+			start := time.Now()
+			<-p.done
+			// the peer was reachable for a while; start over
+			if time.Since(start) > maxRedialBackoff {
+				backoff = minRedialBackoff
+			}
+		}
+		// jitter, lest peers that lost each other redial in lockstep
+		d := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("reconnecting to peer %s in %v\n", addr, d.Round(time.Millisecond))
+		time.Sleep(d)
+		backoff *= 2
+		if backoff > maxRedialBackoff {
+			backoff = maxRedialBackoff
+		}
 	}
-	log.Printf("key exchanged with peer %s, our key %x, peer key %x\n", id, encoderKey[:], decoderKey[:])
+}
 
-	p := newPeer(id, conn, c.decodedTransaction, nil, c.K, c.M, c.solitonC, c.solitonDelta, c.initRate, c.minRate, c.incConstant, c.targetLoss, c.decodeTimeout, encoderKey, decoderKey)
+// handshake secures conn if the node has an identity, and exchanges keys
+// with the peer.
+func (c *controller) handshake(id string, conn net.Conn, dialed bool) (*streamTransport, [ldpc.SaltSize]byte, [ldpc.SaltSize]byte, error) {
+	var encoderKey, derivedKey [ldpc.SaltSize]byte
+	if c.identity != nil {
+		var peerIdentity string
+		var err error
+		conn, encoderKey, derivedKey, peerIdentity, err = c.identity.secure(conn, dialed)
+		if err != nil {
+			return nil, encoderKey, derivedKey, err
+		}
+		log.Printf("peer %s authenticated with identity %s\n", id, peerIdentity)
+	} else {
+		rand.Read(encoderKey[:])
+	}
+	magic := streamMagic
+	if c.block != nil {
+		magic = blockStreamMagic
+	}
+	t, decoderKey, err := newStreamTransport(conn, magic, encoderKey)
+	if err != nil {
+		return nil, encoderKey, decoderKey, err
+	}
+	if c.identity != nil && decoderKey != derivedKey {
+		t.Close()
+		return nil, encoderKey, decoderKey, errors.New("peer key does not match the session")
+	}
+	log.Printf("key exchanged with peer %s, protocol version %d, our key %x, peer key %x\n", id, t.version, encoderKey[:], decoderKey[:])
+	return t, encoderKey, decoderKey, nil
+}
 
+// handleSession starts exchanging codewords over s once the keys have been
+// exchanged.
+func (c *controller) handleSession(s *udpSession) {
+	p := newPeer(s.addr.String(), s, c.decodedTransaction, c.peerGone, nil, c.K, c.M, c.solitonC, c.solitonDelta, c.initRate, c.minRate, c.incConstant, c.targetLoss, c.decodeTimeout, s.ourKey, s.peerKey)
 	c.newPeer <- p
-	return nil
 }
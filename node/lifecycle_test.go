@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clusterNode is a node listening on loopback whose connections can all be
+// cut at once, as if the process was killed.
+type clusterNode struct {
+	*controller
+	addr string
+	l    net.Listener
+
+	lock  sync.Mutex
+	conns []net.Conn
+	dead  bool
+}
+
+// startClusterNode starts a node at addr, running the block protocol if
+// block is not nil.
+func startClusterNode(t *testing.T, addr string, block *blockConfig) *clusterNode {
+	t.Helper()
+	sketch, err := ddsketch.NewDefaultDDSketchWithExactSummaryStatistics(0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &controller{
+		newPeer:            make(chan *peer),
+		peerGone:           make(chan *peer),
+		decodedTransaction: make(chan peerDecodedTransaction, 1000),
+		blockDecoded:       make(chan receivedTransaction, 1000),
+		localTransaction:   make(chan *ldpc.Transaction, 1000),
+		K:                  50,
+		M:                  262144,
+		solitonC:           0.03,
+		solitonDelta:       0.5,
+		initRate:           500,
+		minRate:            100,
+		incConstant:        0.1,
+		targetLoss:         0.02,
+		decodeTimeout:      500 * time.Millisecond,
+		delaySketch:        sketch,
+		warmupTime:         time.Hour,
+		block:              block,
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &clusterNode{controller: c, addr: l.Addr().String(), l: l}
+	t.Cleanup(n.kill)
+	go c.loop()
+	go func() {
+		for {
+			cn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if n.track(cn) {
+				go c.handleConn(cn.RemoteAddr().String(), cn, false)
+			}
+		}
+	}()
+	return n
+}
+
+// track records cn to be closed when the node is killed, and returns false
+// if the node is already dead.
+func (n *clusterNode) track(cn net.Conn) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.dead {
+		cn.Close()
+		return false
+	}
+	n.conns = append(n.conns, cn)
+	return true
+}
+
+func (n *clusterNode) connect(peer string) {
+	go n.maintainOutbound(peer, func(addr string) (net.Conn, error) {
+		n.lock.Lock()
+		dead := n.dead
+		n.lock.Unlock()
+		if dead {
+			// park the goroutine of the killed node
+			select {}
+		}
+		cn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if !n.track(cn) {
+			return nil, net.ErrClosed
+		}
+		return cn, nil
+	})
+}
+
+func (n *clusterNode) kill() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.dead = true
+	n.l.Close()
+	for _, cn := range n.conns {
+		cn.Close()
+	}
+}
+
+func (n *clusterNode) submit(count int) {
+	for i := 0; i < count; i++ {
+		n.localTransaction <- randomTransaction()
+		// well below the codeword rate
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestPeerRestart kills one node of a three-node cluster, checks that the
+// other two keep exchanging transactions, and then restarts it on the same
+// address and checks that its peers reconnect.
+func TestPeerRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a cluster for seconds")
+	}
+	a := startClusterNode(t, "127.0.0.1:0", nil)
+	b := startClusterNode(t, "127.0.0.1:0", nil)
+	c := startClusterNode(t, "127.0.0.1:0", nil)
+	a.connect(b.addr)
+	a.connect(c.addr)
+	b.connect(c.addr)
+	for _, n := range []*clusterNode{a, b, c} {
+		waitFor(t, "peers to connect", func() bool { return n.peerCount.Load() == 2 })
+	}
+
+	const batch = 200
+	a.submit(batch)
+	waitFor(t, "transactions to propagate", func() bool {
+		return b.decodedCount.Load() >= batch && c.decodedCount.Load() >= batch
+	})
+
+	b.kill()
+	waitFor(t, "b to be disconnected", func() bool {
+		return a.peerCount.Load() == 1 && c.peerCount.Load() == 1
+	})
+	before := c.decodedCount.Load()
+	a.submit(batch)
+	waitFor(t, "transactions to propagate without b", func() bool {
+		return c.decodedCount.Load() >= before+batch
+	})
+
+	// a redials b; the restarted b dials c again
+	b = startClusterNode(t, b.addr, nil)
+	b.connect(c.addr)
+	for _, n := range []*clusterNode{a, b, c} {
+		waitFor(t, "peers to reconnect", func() bool { return n.peerCount.Load() == 2 })
+	}
+	beforeA, beforeC := a.decodedCount.Load(), c.decodedCount.Load()
+	b.submit(batch)
+	waitFor(t, "transactions to propagate from the restarted b", func() bool {
+		return a.decodedCount.Load() >= beforeA+batch && c.decodedCount.Load() >= beforeC+batch
+	})
+}
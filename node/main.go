@@ -1,12 +1,14 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"runtime"
 	"syscall"
 	"github.com/yangl1996/rateless-set-reconcile/ldpc"
 	"log"
 	"net"
 	"math/rand"
+	"net/http"
 	"time"
 	"flag"
 	"strings"
@@ -16,8 +18,13 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func getDelayUs(t *ldpc.Transaction) float64 {
-	dt := t.Serialized()
+// minTxSize is the size of the time a transaction was created, which every
+// transaction starts with.
+const minTxSize = 8
+
+// getDelayUs returns the time since the serialized transaction dt was
+// generated. dt must be at least minTxSize bytes.
+func getDelayUs(dt []byte) float64 {
 	sent := int64(binary.LittleEndian.Uint64(dt[0:8]))
 	rcvd := time.Now().UnixMicro()
 	return float64(rcvd - sent)
@@ -25,11 +32,11 @@ func getDelayUs(t *ldpc.Transaction) float64 {
 
 func randomTransaction() *ldpc.Transaction {
 	ut := uint64(time.Now().UnixMicro())
-    d := ldpc.TransactionData{}
+    d := make([]byte, ldpc.TxSize)
 	binary.LittleEndian.PutUint64(d[0:8], ut)
     rand.Read(d[8:])
     t := &ldpc.Transaction{}
-    t.UnmarshalBinary(d[:])
+    t.UnmarshalBinary(d)
     return t
 }
 
@@ -50,6 +57,17 @@ func main() {
 	targetLoss := flag.Float64("loss", 0.02, "target codeword loss rate")
 	decodeTimeout := flag.Duration("t", 500 * time.Millisecond, "codeword decoding timeout")
 	tcpWriteBuffer := flag.Int("tcpbuffer", 65000, "tcp write buffer size")
+	useUDP := flag.Bool("udp", false, "exchange codewords over udp instead of tcp")
+	mtu := flag.Int("mtu", 1400, "path mtu in bytes, which udp packets are sized to")
+	udpLoss := flag.Float64("udploss", 0, "drop outgoing udp packets with this probability, for testing")
+	identityPath := flag.String("identity", "", "file of the ed25519 identity key, generated if missing; enables secure sessions")
+	trustedPath := flag.String("trusted", "", "file of the hex-encoded public keys of trusted peers, one per line")
+	coding := flag.String("coding", "ldpc", "coding protocol, options are ldpc and riblt")
+	controlOverhead := flag.Float64("overhead", 0.10, "control overhead of the riblt protocol (ratio between the max number of coded symbols in flight and the size of the last block)")
+	numShards := flag.Int("shards", 64, "number of shards of the riblt protocol")
+	metricsAddr := flag.String("metrics", "", "address to serve metrics at; disabled if empty")
+	apiAddr := flag.String("api", "", "address to serve the local api at, or unix:path for a unix socket; disabled if empty")
+	blockInterval := flag.Duration("blockintv", 10*time.Millisecond, "min interval between riblt blocks")
 	flag.Parse()
 
 	flag.VisitAll(func(f *flag.Flag) {
@@ -70,7 +88,9 @@ func main() {
 	}
 	c := &controller {
 		newPeer: make(chan *peer),
-		decodedTransaction: make(chan ldpc.DecodedTransaction, 1000),
+		peerGone: make(chan *peer),
+		decodedTransaction: make(chan peerDecodedTransaction, 1000),
+		blockDecoded: make(chan receivedTransaction, 1000),
 		localTransaction: make(chan *ldpc.Transaction, 1000),
 		K: *K,
 		M: *M,
@@ -85,13 +105,117 @@ func main() {
 		warmupTime: *warmup,
 	}
 
+	switch *coding {
+	case "ldpc":
+	case "riblt":
+		if *useUDP {
+			log.Fatalln("the riblt protocol is only supported over tcp")
+		}
+		if *numShards < 1 {
+			log.Fatalln("the riblt protocol needs at least one shard")
+		}
+		c.block = &blockConfig{
+			controlOverhead: *controlOverhead,
+			numShards: *numShards,
+			blockInterval: *blockInterval,
+		}
+	default:
+		log.Fatalln("unknown coding protocol", *coding)
+	}
+
+	if *identityPath != "" {
+		if *useUDP {
+			log.Fatalln("secure sessions are only supported over tcp")
+		}
+		key, err := loadIdentityKey(*identityPath)
+		if err != nil {
+			log.Fatalln("failed to load identity key:", err)
+		}
+		trusted := []ed25519.PublicKey{}
+		if *trustedPath != "" {
+			trusted, err = loadTrustedKeys(*trustedPath)
+			if err != nil {
+				log.Fatalln("failed to load trusted keys:", err)
+			}
+		}
+		c.identity, err = newIdentity(key, trusted)
+		if err != nil {
+			log.Fatalln("failed to create identity:", err)
+		}
+		log.Printf("identity %s, %d trusted peers\n", c.identity.publicKey(), len(trusted))
+	}
+
 	go c.loop()
 
+	if *metricsAddr != "" {
+		l, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			log.Fatalln("failed to listen for metrics:", err)
+		}
+		log.Println("serving metrics at", l.Addr())
+		go func() {
+			err := http.Serve(l, newMetricsHandler(c))
+			log.Fatalln("error serving metrics:", err)
+		}()
+	}
+
+	if *apiAddr != "" {
+		l, err := listenAPI(*apiAddr)
+		if err != nil {
+			log.Fatalln("failed to listen for the api:", err)
+		}
+		log.Println("serving the api at", l.Addr())
+		go func() {
+			err := http.Serve(l, newAPIHandler(c))
+			log.Fatalln("error serving the api:", err)
+		}()
+	}
+
+	if *useUDP {
+		startUDP(c, *addr, *conn, *mtu, *udpLoss)
+	} else {
+		startTCP(c, *addr, *conn, *tcpWriteBuffer)
+	}
+
+	if *txRate > 0 {
+		r := *txRate
+		go func() {
+			cnt := 0
+			timer := time.NewTimer(time.Duration(rand.ExpFloat64() / r * float64(time.Second)))
+			ticker := time.NewTicker(time.Duration(1) * time.Second)
+			for {
+				select {
+				case <-ticker.C:
+					log.Printf("generated tx %d\n", cnt)
+				case <-timer.C:
+					timer.Reset(time.Duration(rand.ExpFloat64() / r * float64(time.Second)))
+					// the generator submits like any api client
+					tx := randomTransaction()
+					c.submit(context.Background(), tx)
+					cnt += 1
+				}
+			}
+		}()
+	}
+
+	log.Println("running")
+
+	var m runtime.MemStats
+	for {
+		runtime.ReadMemStats(&m)
+		log.Printf("Heap=%v MB, Sys=%v MB, GCCycles=%v\n", m.Alloc/1024/1024, m.Sys/1024/1024, m.NumGC)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// startTCP listens at addr and connects to the comma-delimited list of
+// addresses in conn over tcp.
+func startTCP(c *controller, addr string, conn string, tcpWriteBuffer int) {
 	// function to set the write buffer size
 	swb := func(network, address string, c syscall.RawConn) error {
 		var err error
 		c.Control(func(fd uintptr) {
-			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF, *tcpWriteBuffer)
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF, tcpWriteBuffer)
 			if err != nil {
 				return
 			}
@@ -102,7 +226,7 @@ func main() {
 	lconf := &net.ListenConfig{
 		Control: swb,
 	}
-	l, err := lconf.Listen(context.Background(), "tcp", *addr)
+	l, err := lconf.Listen(context.Background(), "tcp", addr)
 	if err != nil {
 		log.Fatalln("failed to listen:", err)
 	} else {
@@ -114,64 +238,59 @@ func main() {
 			if err != nil {
 				log.Println("error accepting incoming connection:", err)
 			} else {
-				c.handleConn(cn.RemoteAddr().String(), cn)
+				go c.handleConn(cn.RemoteAddr().String(), cn, false)
 			}
 		}
 	}()
 
-	if *conn != "" {
-		addrList := strings.Split(*conn, ",")
+	if conn != "" {
+		addrList := strings.Split(conn, ",")
 		for _, a := range addrList {
-			go func(addr string) {
-				var cn net.Conn
-				var err error
-				for {
-					dialer := &net.Dialer{
-						Control: swb,
-					}
-					cn, err = dialer.Dial("tcp", addr)
-					if err != nil {
-						log.Println("error connecting:", err)
-						time.Sleep(time.Duration(1 * time.Second))
-					} else {
-						break
-					}
+			go c.maintainOutbound(a, func(addr string) (net.Conn, error) {
+				dialer := &net.Dialer{
+					Control: swb,
 				}
-				err = cn.(*net.TCPConn).SetWriteBuffer(*tcpWriteBuffer)
+				cn, err := dialer.Dial("tcp", addr)
 				if err != nil {
-					log.Fatalln("failed to set write buffer:", err)
+					return nil, err
 				}
-				c.handleConn(cn.RemoteAddr().String(), cn)
-			}(a)
+				err = cn.(*net.TCPConn).SetWriteBuffer(tcpWriteBuffer)
+				if err != nil {
+					cn.Close()
+					return nil, err
+				}
+				return cn, nil
+			})
 		}
 	}
+}
 
-	if *txRate > 0 {
-		r := *txRate
-		go func() {
-			cnt := 0
-			timer := time.NewTimer(time.Duration(rand.ExpFloat64() / r * float64(time.Second)))
-			ticker := time.NewTicker(time.Duration(1) * time.Second)
-			for {
-				select {
-				case <-ticker.C:
-					log.Printf("generated tx %d\n", cnt)
-				case <-timer.C:
-					timer.Reset(time.Duration(rand.ExpFloat64() / r * float64(time.Second)))
-					tx := randomTransaction()
-					c.localTransaction <- tx
-					cnt += 1
-				}
-			}
-		}()
+// startUDP exchanges codewords with peers over one udp socket bound to addr,
+// and starts sessions with the comma-delimited list of addresses in conn.
+func startUDP(c *controller, addr string, conn string, mtu int, loss float64) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Fatalln("failed to listen:", err)
+	} else {
+		log.Println("start listening at", pc.LocalAddr())
 	}
-
-	log.Println("running")
-
-	var m runtime.MemStats
-	for {
-		runtime.ReadMemStats(&m)
-		log.Printf("Heap=%v MB, Sys=%v MB, GCCycles=%v\n", m.Alloc/1024/1024, m.Sys/1024/1024, m.NumGC)
-		time.Sleep(1 * time.Second)
+	if loss > 0 {
+		pc = newLossyPacketConn(pc, loss)
+	}
+	ep := newUDPEndpoint(pc, mtu, c.handleSession)
+	go func() {
+		err := ep.serve()
+		if err != nil {
+			log.Fatalln("error reading udp packets:", err)
+		}
+	}()
+	go ep.maintain()
+	if conn != "" {
+		for _, a := range strings.Split(conn, ",") {
+			err := ep.dial(a)
+			if err != nil {
+				log.Println("error connecting:", err)
+			}
+		}
 	}
 }
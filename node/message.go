@@ -1,7 +1,12 @@
 package main
 
 import (
-    "github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+	"io"
 )
 
 type Codeword struct {
@@ -13,3 +18,158 @@ type Codeword struct {
 type HashKey struct {
 	key [ldpc.SaltSize]byte
 }
+
+var errMalformedCodeword = errors.New("malformed codeword")
+
+// A codeword is serialized as
+//
+//	send time in Unix microseconds (8) | symbol size (uvarint) | symbol |
+//	number of members (uvarint) | members (4 each)
+//
+// with integers in little endian. The loss it carries is sent separately by
+// each transport.
+func codewordSize(cw Codeword) int {
+	n := len(cw.Members)
+	return 8 + uvarintSize(uint64(len(cw.Symbol))) + len(cw.Symbol) + uvarintSize(uint64(n)) + 4*n
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n += 1
+	}
+	return n
+}
+
+func appendCodeword(buf []byte, cw Codeword) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(cw.UnixMicro))
+	buf = binary.AppendUvarint(buf, uint64(len(cw.Symbol)))
+	buf = append(buf, cw.Symbol...)
+	buf = binary.AppendUvarint(buf, uint64(len(cw.Members)))
+	for _, m := range cw.Members {
+		buf = binary.LittleEndian.AppendUint32(buf, m)
+	}
+	return buf
+}
+
+// readCodeword reads a codeword serialized by appendCodeword from r.
+func readCodeword(r *bytes.Reader) (Codeword, error) {
+	var hdr [8]byte
+	cw := Codeword{Codeword: &ldpc.Codeword{}}
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return cw, errMalformedCodeword
+	}
+	cw.UnixMicro = int64(binary.LittleEndian.Uint64(hdr[:]))
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return cw, errMalformedCodeword
+	}
+	if size != 0 {
+		cw.Symbol = make(ldpc.TransactionData, size)
+		io.ReadFull(r, cw.Symbol)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()/4) {
+		return cw, errMalformedCodeword
+	}
+	if n != 0 {
+		cw.Members = make([]uint32, n)
+	}
+	var m [4]byte
+	for i := range cw.Members {
+		io.ReadFull(r, m[:])
+		cw.Members[i] = binary.LittleEndian.Uint32(m[:])
+	}
+	return cw, nil
+}
+
+// A blockSymbol is serialized as
+//
+//	flags (1) | start hash (8) | end hash (8) | coded symbol
+//
+// where bit 0 of flags is set for the first coded symbol of a block, the
+// hashes are present only then, and the coded symbol is marshaled by riblt
+// with blockTransactionCodec.
+func appendBlockSymbol(buf []byte, m blockSymbol) []byte {
+	if !m.newBlock {
+		buf = append(buf, 0)
+	} else {
+		buf = append(buf, 1)
+		buf = binary.LittleEndian.AppendUint64(buf, m.startHash)
+		buf = binary.LittleEndian.AppendUint64(buf, m.endHash)
+	}
+	cs, _ := m.CodedSymbol.Marshal(blockTransactionCodec{})
+	return append(buf, cs...)
+}
+
+func readBlockSymbol(data []byte) (blockSymbol, error) {
+	m := blockSymbol{}
+	if len(data) == 0 || data[0] > 1 {
+		return m, errMalformedCodeword
+	}
+	m.newBlock = data[0] == 1
+	data = data[1:]
+	if m.newBlock {
+		if len(data) < 16 {
+			return m, errMalformedCodeword
+		}
+		m.startHash = binary.LittleEndian.Uint64(data[0:8])
+		m.endHash = binary.LittleEndian.Uint64(data[8:16])
+		data = data[16:]
+	}
+	err := m.CodedSymbol.Unmarshal(blockTransactionCodec{}, data)
+	return m, err
+}
+
+// A blockAck is serialized as flags (1) followed by its transactions as
+// serialized by appendBlockTransactions, where bit 0 of flags is ackStart
+// and bit 1 is ackBlock.
+func appendBlockAck(buf []byte, m blockAck) []byte {
+	var flags byte
+	if m.ackStart {
+		flags |= 1
+	}
+	if m.ackBlock {
+		flags |= 2
+	}
+	buf = append(buf, flags)
+	return appendBlockTransactions(buf, m.txs)
+}
+
+func readBlockAck(data []byte) (blockAck, error) {
+	m := blockAck{}
+	if len(data) == 0 || data[0] > 3 {
+		return m, errMalformedCodeword
+	}
+	m.ackStart = data[0]&1 != 0
+	m.ackBlock = data[0]&2 != 0
+	txs, err := readBlockTransactions(data[1:])
+	m.txs = txs
+	return m, err
+}
+
+// Transactions are serialized as their number (uvarint) followed by each
+// of them (ldpc.TxSize each).
+func appendBlockTransactions(buf []byte, txs []riblt.HashedSymbol[blockTransaction]) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		buf = append(buf, tx.Symbol[:]...)
+	}
+	return buf
+}
+
+func readBlockTransactions(data []byte) (blockTransactions, error) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)) || uint64(len(data)-l) != n*ldpc.TxSize {
+		return nil, errMalformedCodeword
+	}
+	data = data[l:]
+	var txs blockTransactions
+	for i := 0; i < int(n); i++ {
+		var tx blockTransaction
+		copy(tx[:], data[i*ldpc.TxSize:])
+		txs = append(txs, hashedBlockTransaction(tx))
+	}
+	return txs, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/DataDog/sketches-go/ddsketch"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The node exposes its metrics over HTTP in the text format of Prometheus.
+// Counters and gauges are updated by the goroutines of each peer as they go,
+// and the latency sketches, which are not safe for concurrent use, are
+// copied once a second by their owners.
+
+// latencyBuckets are the upper bounds in milliseconds of the buckets of the
+// latency histograms.
+var latencyBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000}
+
+type peerMetrics struct {
+	codewordsSent     atomic.Uint64
+	codewordsDropped  atomic.Uint64 // not sent as the transport is behind
+	codewordsReceived atomic.Uint64
+	codewordsLost     atomic.Uint64 // received but not decoded in time
+	decoded           atomic.Uint64 // transactions decoded from the peer
+	pending           atomic.Int64  // codewords received and not decoded, or in flight
+	cwRate            atomic.Uint64 // math.Float64bits of the codeword rate
+
+	lock  sync.Mutex
+	delay *ddsketch.DDSketchWithExactSummaryStatistics // codeword delay in ms since connected
+}
+
+func (m *peerMetrics) setRate(r float64) {
+	m.cwRate.Store(math.Float64bits(r))
+}
+
+// mergeDelay adds the codeword delays in s to the metrics.
+func (m *peerMetrics) mergeDelay(s *ddsketch.DDSketchWithExactSummaryStatistics) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.delay == nil {
+		m.delay, _ = ddsketch.NewDefaultDDSketchWithExactSummaryStatistics(0.001)
+	}
+	// MergeWith trips on the store of a sketch that was cleared, so add the
+	// bins one by one; the sum is then within the accuracy of the sketch
+	s.ForEach(func(value, count float64) bool {
+		m.delay.AddWithCount(value, count)
+		return false
+	})
+}
+
+// metricsRegistry holds what the metrics endpoint reports. The zero value
+// is empty.
+type metricsRegistry struct {
+	lock    sync.Mutex
+	peers   map[*peer]struct{}
+	latency *ddsketch.DDSketchWithExactSummaryStatistics // transaction latency in ms since warmup
+}
+
+func (r *metricsRegistry) addPeer(p *peer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[*peer]struct{})
+	}
+	r.peers[p] = struct{}{}
+}
+
+func (r *metricsRegistry) removePeer(p *peer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.peers, p)
+}
+
+// setLatency replaces the latency sketch with s, which the registry owns
+// afterwards.
+func (r *metricsRegistry) setLatency(s *ddsketch.DDSketchWithExactSummaryStatistics) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.latency = s
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+func (w metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w metricsWriter) sample(name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s%s %v\n", name, labels, v)
+}
+
+// histogram writes the samples of a histogram derived from s, which may be
+// nil.
+func (w metricsWriter) histogram(name, labels string, s *ddsketch.DDSketchWithExactSummaryStatistics) {
+	counts := make([]float64, len(latencyBuckets))
+	var count, sum float64
+	if s != nil {
+		s.ForEach(func(value, c float64) bool {
+			i := sort.SearchFloat64s(latencyBuckets, value)
+			if i < len(counts) {
+				counts[i] += c
+			}
+			return false
+		})
+		count, sum = s.GetCount(), s.GetSum()
+	}
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	cum := 0.0
+	for i, b := range latencyBuckets {
+		cum += counts[i]
+		w.sample(name+"_bucket", fmt.Sprintf(`%s%sle="%v"`, labels, sep, b), cum)
+	}
+	w.sample(name+"_bucket", fmt.Sprintf(`%s%sle="+Inf"`, labels, sep), count)
+	w.sample(name+"_sum", labels, sum)
+	w.sample(name+"_count", labels, count)
+}
+
+// writeMetrics writes the metrics of c to out.
+func (c *controller) writeMetrics(out io.Writer) error {
+	w := metricsWriter{bufio.NewWriter(out)}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	c.metrics.lock.Lock()
+	peers := make([]*peer, 0, len(c.metrics.peers))
+	for p := range c.metrics.peers {
+		peers = append(peers, p)
+	}
+	var latency *ddsketch.DDSketchWithExactSummaryStatistics
+	if c.metrics.latency != nil {
+		latency = c.metrics.latency.Copy()
+	}
+	c.metrics.lock.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].id < peers[j].id })
+
+	w.header("node_decoded_transactions_total", "counter", "Transactions decoded from all peers.")
+	w.sample("node_decoded_transactions_total", "", float64(c.decodedCount.Load()))
+	w.header("node_peers", "gauge", "Connected peers.")
+	w.sample("node_peers", "", float64(c.peerCount.Load()))
+	w.header("node_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	w.sample("node_heap_alloc_bytes", "", float64(ms.HeapAlloc))
+	w.header("node_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	w.sample("node_sys_bytes", "", float64(ms.Sys))
+	w.header("node_transaction_latency_ms", "histogram", "Latency of decoded transactions since warmup, in milliseconds.")
+	w.histogram("node_transaction_latency_ms", "", latency)
+
+	perPeer := []struct {
+		name, typ, help string
+		value           func(m *peerMetrics) float64
+	}{
+		{"node_peer_codewords_sent_total", "counter", "Codewords sent to the peer.", func(m *peerMetrics) float64 { return float64(m.codewordsSent.Load()) }},
+		{"node_peer_codewords_dropped_total", "counter", "Codewords not sent to the peer as the transport is behind.", func(m *peerMetrics) float64 { return float64(m.codewordsDropped.Load()) }},
+		{"node_peer_codewords_received_total", "counter", "Codewords received from the peer.", func(m *peerMetrics) float64 { return float64(m.codewordsReceived.Load()) }},
+		{"node_peer_codewords_lost_total", "counter", "Codewords from the peer that were not decoded in time.", func(m *peerMetrics) float64 { return float64(m.codewordsLost.Load()) }},
+		{"node_peer_codeword_rate", "gauge", "Rate of codewords sent to the peer per second.", func(m *peerMetrics) float64 { return math.Float64frombits(m.cwRate.Load()) }},
+		{"node_peer_decoded_transactions_total", "counter", "Transactions decoded from the peer.", func(m *peerMetrics) float64 { return float64(m.decoded.Load()) }},
+		{"node_peer_pending_codewords", "gauge", "Codewords from the peer not decoded yet, or to the peer in flight.", func(m *peerMetrics) float64 { return float64(m.pending.Load()) }},
+	}
+	for _, mt := range perPeer {
+		w.header(mt.name, mt.typ, mt.help)
+		for _, p := range peers {
+			w.sample(mt.name, fmt.Sprintf(`peer="%s"`, labelEscaper.Replace(p.id)), mt.value(p.metrics))
+		}
+	}
+	w.header("node_peer_codeword_delay_ms", "histogram", "Delay of codewords from the peer, in milliseconds.")
+	for _, p := range peers {
+		p.metrics.lock.Lock()
+		var delay *ddsketch.DDSketchWithExactSummaryStatistics
+		if p.metrics.delay != nil {
+			delay = p.metrics.delay.Copy()
+		}
+		p.metrics.lock.Unlock()
+		w.histogram("node_peer_codeword_delay_ms", fmt.Sprintf(`peer="%s"`, labelEscaper.Replace(p.id)), delay)
+	}
+	return w.Flush()
+}
+
+// newMetricsHandler returns the handler of the metrics endpoint of c.
+func newMetricsHandler(c *controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		c.writeMetrics(w)
+	})
+	return mux
+}
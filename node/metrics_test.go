@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/DataDog/sketches-go/ddsketch"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseMetrics returns the samples in the text format read from r, keyed by
+// the name and labels.
+func parseMetrics(t *testing.T, r io.Reader) map[string]float64 {
+	t.Helper()
+	res := make(map[string]float64)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("malformed sample %q", line)
+		}
+		res[line[:i]] = v
+	}
+	return res
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	s, err := ddsketch.NewDefaultDDSketchWithExactSummaryStatistics(0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{0.5, 3, 700, 40000} {
+		s.Add(v)
+	}
+	buf := &bytes.Buffer{}
+	w := metricsWriter{bufio.NewWriter(buf)}
+	w.histogram("h", `peer="a"`, s)
+	w.Flush()
+	m := parseMetrics(t, buf)
+	expected := map[string]float64{
+		`h_bucket{peer="a",le="1"}`:     1,
+		`h_bucket{peer="a",le="2"}`:     1,
+		`h_bucket{peer="a",le="5"}`:     2,
+		`h_bucket{peer="a",le="1000"}`:  3,
+		`h_bucket{peer="a",le="30000"}`: 3,
+		`h_bucket{peer="a",le="+Inf"}`:  4,
+		`h_count{peer="a"}`:             4,
+		`h_sum{peer="a"}`:               40703.5,
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("%s is %v, expected %v", k, m[k], v)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	a := startClusterNode(t, "127.0.0.1:0", nil)
+	b := startClusterNode(t, "127.0.0.1:0", nil)
+	a.connect(b.addr)
+	waitFor(t, "peers to connect", func() bool { return b.peerCount.Load() == 1 })
+	const batch = 100
+	a.submit(batch)
+	waitFor(t, "transactions to propagate", func() bool { return b.decodedCount.Load() >= batch*9/10 })
+
+	srv := httptest.NewServer(newMetricsHandler(b.controller))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	m := parseMetrics(t, resp.Body)
+	// the node may decode more after the scrape
+	if m["node_peers"] != 1 || m["node_decoded_transactions_total"] < batch*9/10 || m["node_decoded_transactions_total"] > float64(b.decodedCount.Load()) {
+		t.Errorf("node metrics do not match the controller")
+	}
+	var received, decoded float64
+	peers := 0
+	for k, v := range m {
+		if strings.HasPrefix(k, "node_peer_codewords_received_total{") {
+			received = v
+			peers += 1
+		}
+		if strings.HasPrefix(k, "node_peer_decoded_transactions_total{") {
+			decoded = v
+		}
+	}
+	if peers != 1 || received == 0 || decoded < batch*9/10 {
+		t.Errorf("%d peers, %v codewords received, %v transactions decoded", peers, received, decoded)
+	}
+}
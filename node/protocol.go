@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// Over a stream connection such as TCP, peers first exchange a hello
+//
+//	magic (4 bytes) | min version (1) | max version (1) | key (ldpc.SaltSize)
+//
+// and speak the highest version that both support. Messages then follow as
+// frames
+//
+//	length (4, little endian) | type (1) | payload (length-1 bytes)
+//
+// of at most maxFrameSize bytes. Frames of types the reader does not expect
+// are ignored, so that later versions may add them. Version 2 has
+// frameCodeword only, whose payload is the loss it carries (uvarint)
+// followed by the codeword serialized by appendCodeword. A peer that sends a
+// frame that is too large, or a malformed frame of an expected type, is
+// disconnected. Version 1, whose symbols had the fixed size ldpc.TxSize, is
+// no longer spoken.
+//
+// Nodes running the block protocol send blockStreamMagic instead, so that
+// they do not connect to nodes running the ldpc protocol, and exchange
+// frameBlockSymbol, frameBlockAck and frameBlockTransactions, whose
+// payloads are serialized by appendBlockSymbol, appendBlockAck and
+// appendBlockTransactions.
+var (
+	streamMagic      = [4]byte{'R', 'S', 'R', 'C'}
+	blockStreamMagic = [4]byte{'R', 'S', 'R', 'B'}
+)
+
+const (
+	minProtocolVersion = 2
+	maxProtocolVersion = 2
+	helloSize          = 4 + 2 + ldpc.SaltSize
+	maxFrameSize       = 1 << 20
+	handshakeTimeout   = 10 * time.Second
+)
+
+const (
+	frameCodeword byte = iota + 1
+	frameBlockSymbol
+	frameBlockAck
+	frameBlockTransactions
+)
+
+var (
+	errBadMagic          = errors.New("bad magic bytes")
+	errCodingMismatch    = errors.New("peer runs the other coding protocol")
+	errUnexpectedMessage = errors.New("unexpected message")
+	errFrameSize         = errors.New("frame size out of range")
+	errMalformedFrame    = errors.New("malformed frame")
+)
+
+// VersionError is returned by the handshake when the peer supports none of
+// the protocol versions we do.
+type VersionError struct {
+	Min, Max byte // versions supported by the peer
+}
+
+func (e VersionError) Error() string {
+	return fmt.Sprintf("peer speaks protocol versions %d to %d, we speak %d to %d", e.Min, e.Max, minProtocolVersion, maxProtocolVersion)
+}
+
+// streamTransport carries codewords over a stream connection in frames.
+type streamTransport struct {
+	conn    net.Conn
+	version byte
+	w       *bufio.Writer
+	r       *bufio.Reader
+	wbuf    []byte
+	rbuf    []byte
+}
+
+// newStreamTransport exchanges hellos starting with magic over conn,
+// sending key as ours. It returns the transport and the key of the peer.
+// The caller should close conn if it fails, which also stops sending our
+// hello.
+func newStreamTransport(conn net.Conn, magic [4]byte, key [ldpc.SaltSize]byte) (*streamTransport, [ldpc.SaltSize]byte, error) {
+	var peerKey [ldpc.SaltSize]byte
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	hello := make([]byte, 0, helloSize)
+	hello = append(hello, magic[:]...)
+	hello = append(hello, minProtocolVersion, maxProtocolVersion)
+	hello = append(hello, key[:]...)
+	// send and receive at the same time, in case conn does not buffer
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(hello)
+		errc <- err
+	}()
+	buf := make([]byte, helloSize)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, peerKey, err
+	}
+	if !bytes.Equal(buf[0:4], magic[:]) {
+		if bytes.Equal(buf[0:4], streamMagic[:]) || bytes.Equal(buf[0:4], blockStreamMagic[:]) {
+			return nil, peerKey, errCodingMismatch
+		}
+		return nil, peerKey, errBadMagic
+	}
+	peerMin, peerMax := buf[4], buf[5]
+	version := byte(maxProtocolVersion)
+	if peerMax < version {
+		version = peerMax
+	}
+	if version < minProtocolVersion || version < peerMin {
+		return nil, peerKey, VersionError{peerMin, peerMax}
+	}
+	if err := <-errc; err != nil {
+		return nil, peerKey, err
+	}
+	copy(peerKey[:], buf[6:])
+	conn.SetDeadline(time.Time{})
+	t := &streamTransport{
+		conn:    conn,
+		version: version,
+		w:       bufio.NewWriter(conn),
+		r:       bufio.NewReader(conn),
+	}
+	return t, peerKey, nil
+}
+
+func (t *streamTransport) writeFrame(typ byte, payload []byte) error {
+	var hdr [5]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(payload)+1))
+	hdr[4] = typ
+	if _, err := t.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := t.w.Write(payload)
+	return err
+}
+
+// readFrame returns the type and the payload of the next frame. The payload
+// is valid until the next call.
+func (t *streamTransport) readFrame() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(t.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[0:4])
+	if n == 0 || n > maxFrameSize {
+		return 0, nil, errFrameSize
+	}
+	if cap(t.rbuf) < int(n-1) {
+		t.rbuf = make([]byte, n-1)
+	}
+	t.rbuf = t.rbuf[:n-1]
+	if _, err := io.ReadFull(t.r, t.rbuf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[4], t.rbuf, nil
+}
+
+func (t *streamTransport) writeCodeword(cw Codeword) error {
+	t.wbuf = binary.AppendUvarint(t.wbuf[:0], uint64(cw.Loss))
+	t.wbuf = appendCodeword(t.wbuf, cw)
+	return t.writeFrame(frameCodeword, t.wbuf)
+}
+
+func (t *streamTransport) flush() error {
+	return t.w.Flush()
+}
+
+func (t *streamTransport) readCodeword() (Codeword, error) {
+	for {
+		typ, payload, err := t.readFrame()
+		if err != nil {
+			return Codeword{}, err
+		}
+		if typ != frameCodeword {
+			// ignored, see streamMagic
+			continue
+		}
+		r := bytes.NewReader(payload)
+		loss, err := binary.ReadUvarint(r)
+		if err != nil || loss > math.MaxInt32 {
+			return Codeword{}, errMalformedFrame
+		}
+		cw, err := readCodeword(r)
+		if err != nil || r.Len() != 0 {
+			return Codeword{}, errMalformedFrame
+		}
+		cw.Loss = int(loss)
+		return cw, nil
+	}
+}
+
+// maxBlockTransactions is the number of transactions that fit in a frame.
+var maxBlockTransactions = (maxFrameSize - 16) / ldpc.TxSize
+
+// writeBlockMessage writes a blockSymbol or a blockAck. The transactions of
+// an ack that do not fit in its frame go ahead of it in frames of their
+// own.
+func (t *streamTransport) writeBlockMessage(m any) error {
+	switch m := m.(type) {
+	case blockSymbol:
+		t.wbuf = appendBlockSymbol(t.wbuf[:0], m)
+		return t.writeFrame(frameBlockSymbol, t.wbuf)
+	case blockAck:
+		for len(m.txs) > maxBlockTransactions {
+			t.wbuf = appendBlockTransactions(t.wbuf[:0], m.txs[:maxBlockTransactions])
+			if err := t.writeFrame(frameBlockTransactions, t.wbuf); err != nil {
+				return err
+			}
+			m.txs = m.txs[maxBlockTransactions:]
+		}
+		t.wbuf = appendBlockAck(t.wbuf[:0], m)
+		return t.writeFrame(frameBlockAck, t.wbuf)
+	default:
+		panic("unknown block message type")
+	}
+}
+
+// readBlockMessage returns the next blockSymbol, blockAck or
+// blockTransactions.
+func (t *streamTransport) readBlockMessage() (any, error) {
+	for {
+		typ, payload, err := t.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		var m any
+		switch typ {
+		case frameBlockSymbol:
+			m, err = readBlockSymbol(payload)
+		case frameBlockAck:
+			m, err = readBlockAck(payload)
+		case frameBlockTransactions:
+			m, err = readBlockTransactions(payload)
+		default:
+			// ignored, see streamMagic
+			continue
+		}
+		if err != nil {
+			return nil, errMalformedFrame
+		}
+		return m, nil
+	}
+}
+
+func (t *streamTransport) Close() error {
+	return t.conn.Close()
+}
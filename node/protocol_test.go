@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"net"
+	"testing"
+)
+
+type handshakeResult struct {
+	t   *streamTransport
+	key [ldpc.SaltSize]byte
+	err error
+}
+
+func handshakePipe(t *testing.T) (*streamTransport, *streamTransport) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	keyA := [ldpc.SaltSize]byte{1}
+	keyB := [ldpc.SaltSize]byte{2}
+	res := make(chan handshakeResult)
+	go func() {
+		ta, k, err := newStreamTransport(a, streamMagic, keyA)
+		res <- handshakeResult{ta, k, err}
+	}()
+	tb, k, err := newStreamTransport(b, streamMagic, keyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != keyA {
+		t.Fatal("wrong key received")
+	}
+	ra := <-res
+	if ra.err != nil {
+		t.Fatal(ra.err)
+	}
+	if ra.key != keyB {
+		t.Fatal("wrong key received")
+	}
+	return ra.t, tb
+}
+
+func TestStreamTransport(t *testing.T) {
+	ta, tb := handshakePipe(t)
+	const n = 100
+	go func() {
+		for i := 0; i < n; i++ {
+			cw := testCodeword(i)
+			cw.Loss = i
+			ta.writeCodeword(cw)
+			if i == n/2 {
+				// frames of unknown types are skipped
+				ta.writeFrame(200, []byte("future"))
+			}
+		}
+		ta.flush()
+	}()
+	for i := 0; i < n; i++ {
+		cw, err := tb.readCodeword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := testCodeword(i)
+		if cw.UnixMicro != exp.UnixMicro || cw.Loss != i || !bytes.Equal(cw.Symbol, exp.Symbol) || len(cw.Members) != len(exp.Members) {
+			t.Fatalf("codeword %d corrupted", i)
+		}
+		for j := range cw.Members {
+			if cw.Members[j] != exp.Members[j] {
+				t.Fatalf("codeword %d corrupted", i)
+			}
+		}
+	}
+}
+
+func TestStreamHandshakeRejected(t *testing.T) {
+	hello := func(min, max byte) []byte {
+		h := append([]byte{}, streamMagic[:]...)
+		h = append(h, min, max)
+		return append(h, make([]byte, ldpc.SaltSize)...)
+	}
+	cases := map[string][]byte{
+		"garbage":  []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		"newer":    hello(maxProtocolVersion+1, maxProtocolVersion+2),
+		"older":    hello(0, 0),
+		"inverted": hello(maxProtocolVersion+1, 0),
+		"gob":      {0x1f, 0xff, 0x81, 0x03, 0x01, 0x01, 0x08, 0x43, 0x6f, 0x64, 0x65, 0x77, 0x6f, 0x72, 0x64, 0x01, 0xff, 0x82, 0x00, 0x01, 0x03},
+	}
+	for name, data := range cases {
+		a, b := net.Pipe()
+		go func() {
+			b.Write(data)
+			b.Close()
+		}()
+		_, _, err := newStreamTransport(a, streamMagic, [ldpc.SaltSize]byte{})
+		if err == nil {
+			t.Errorf("%s: handshake succeeded", name)
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestStreamMalformedFrame(t *testing.T) {
+	frame := func(typ byte, payload []byte) []byte {
+		f := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)+1))
+		f = append(f, typ)
+		return append(f, payload...)
+	}
+	valid := appendCodeword(binary.AppendUvarint(nil, 3), testCodeword(10))
+	// up to the number of members of a codeword with none
+	noMembers := appendCodeword(binary.AppendUvarint(nil, 3), testCodeword(0))
+	noMembers = noMembers[:len(noMembers)-1]
+	cases := map[string][]byte{
+		"empty":     {0, 0, 0, 0},
+		"oversized": binary.LittleEndian.AppendUint32(nil, maxFrameSize+1),
+		"truncated": frame(frameCodeword, valid[:len(valid)-1]),
+		"trailing":  frame(frameCodeword, append(valid, 0)),
+		"members":   frame(frameCodeword, append(noMembers, 0xff, 0xff, 0x03)),
+		"symbol":    frame(frameCodeword, append(binary.AppendUvarint(nil, 3), append(make([]byte, 8), 0xff, 0x03)...)),
+		"short":     frame(frameCodeword, valid)[:20],
+	}
+	for name, data := range cases {
+		ta, tb := handshakePipe(t)
+		go func() {
+			ta.conn.Write(data)
+			ta.Close()
+		}()
+		if _, err := tb.readCodeword(); err == nil {
+			t.Errorf("%s: malformed frame accepted", name)
+		}
+	}
+}
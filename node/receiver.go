@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/gob"
 	"github.com/yangl1996/rateless-set-reconcile/ldpc"
 	"time"
 	"log"
@@ -13,38 +12,55 @@ type receivedCodeword struct {
 	receivedTime time.Time
 }
 
+// peerDecodedTransaction is a transaction decoded from the codewords of a
+// peer.
+type peerDecodedTransaction struct {
+	ldpc.DecodedTransaction
+	from string
+}
+
 type receiver struct {
 	peerId string
-	rx          *gob.Decoder
+	rx          codewordReader
 	decoder                *ldpc.Decoder
 	peerLoss    chan<- int
 	ourLoss     chan<- int
-	decodedTransaction chan<- ldpc.DecodedTransaction
+	decodedTransaction chan<- peerDecodedTransaction
 	newTransaction <-chan *ldpc.Transaction
+	done <-chan struct{} // closed when the peer is disconnected
 
 	rxWindow               []receivedCodeword
 	timeout                time.Duration
 	delaySketch *ddsketch.DDSketchWithExactSummaryStatistics
+	metrics *peerMetrics
 }
 
 func (r *receiver) receive(cw chan<- Codeword) error {
 	for {
-		newcw := Codeword{}
-		err := r.rx.Decode(&newcw)
+		newcw, err := r.rx.readCodeword()
 		if err != nil {
 			return err
 		}
-		cw <- newcw
+		select {
+		case cw <- newcw:
+		case <-r.done:
+			return nil
+		}
 	}
 }
 
-func (r *receiver) decode(cwChan <-chan Codeword) error {
+// decode decodes the codewords from cwChan until the peer is disconnected.
+func (r *receiver) decode(cwChan <-chan Codeword) {
 	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 	cwcnt := 0
 	for {
 		select {
+		case <-r.done:
+			return
 		case cw := <-cwChan:
 			cwcnt += 1
+			r.metrics.codewordsReceived.Add(1)
 			now := time.Now()
 			// clean up the pending codewords
 			loss := 0
@@ -60,10 +76,20 @@ func (r *receiver) decode(cwChan <-chan Codeword) error {
 				head.Free()
 				r.rxWindow = r.rxWindow[1:]
 			}
-			r.ourLoss <- loss
+			r.metrics.codewordsLost.Add(uint64(loss))
+			// the sender stops at the same time
+			select {
+			case r.ourLoss <- loss:
+			case <-r.done:
+				return
+			}
 			// report the loss of the peer
 			if cw.Loss > 0 {
-				r.peerLoss <- cw.Loss
+				select {
+				case r.peerLoss <- cw.Loss:
+				case <-r.done:
+					return
+				}
 			}
 			// record the codeword transmission delay
 			delayms := float64(time.Now().UnixMicro() - cw.UnixMicro) / 1000.0
@@ -71,13 +97,16 @@ func (r *receiver) decode(cwChan <-chan Codeword) error {
 			// try to decode the new codeword
 			stub, buf := r.decoder.AddCodeword(cw.Codeword)
 			r.rxWindow = append(r.rxWindow, receivedCodeword{stub, now})
+			r.metrics.pending.Store(int64(len(r.rxWindow)))
+			r.metrics.decoded.Add(uint64(len(buf)))
 			for _, ntx := range buf {
-				r.decodedTransaction <- ntx
+				r.decodedTransaction <- peerDecodedTransaction{ntx, r.peerId}
 			}
 		case tx := <-r.newTransaction:
 			buf := r.decoder.AddTransaction(tx)
+			r.metrics.decoded.Add(uint64(len(buf)))
 			for _, ntx := range buf {
-				r.decodedTransaction <- ntx
+				r.decodedTransaction <- peerDecodedTransaction{ntx, r.peerId}
 			}
 		case <-ticker.C:
 			qts, err := r.delaySketch.GetValuesAtQuantiles([]float64{0.50, 0.95})
@@ -87,6 +116,7 @@ func (r *receiver) decode(cwChan <-chan Codeword) error {
 			cnt := r.delaySketch.GetCount()
 			sum := r.delaySketch.GetSum()
 			log.Printf("peer %s received cws %d last second delay ms median %.1f p95 %.1f mean %.1f\n", r.peerId, cwcnt, qts[0], qts[1], sum/cnt)
+			r.metrics.mergeDelay(r.delaySketch)
 			r.delaySketch.Clear()
 		}
 	}
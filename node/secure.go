@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Secure sessions run the framed protocol over TLS 1.3, which authenticates
+// and encrypts every frame. Each node has a static ed25519 identity key and
+// accepts peers whose public keys are in its trusted set only, in both
+// directions; certificates are self-signed and carry nothing but the key.
+// The salts of the codes are derived from the session with the TLS exporter
+// instead of being drawn by each side, and are then checked against the
+// keys in the hellos of the framed protocol.
+
+const saltExporterLabel = "EXPORTER-rateless-set-reconcile-salt"
+
+var errUntrustedPeer = errors.New("peer identity not trusted")
+
+// identity is the identity key of the node and the keys of the peers it
+// trusts.
+type identity struct {
+	key     ed25519.PrivateKey
+	cert    tls.Certificate
+	trusted map[string]struct{} // hex-encoded public keys
+}
+
+func newIdentity(key ed25519.PrivateKey, trusted []ed25519.PublicKey) (*identity, error) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC), // no expiration
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	id := &identity{
+		key:     key,
+		cert:    tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		trusted: make(map[string]struct{}),
+	}
+	for _, k := range trusted {
+		id.trusted[hex.EncodeToString(k)] = struct{}{}
+	}
+	return id, nil
+}
+
+func (id *identity) publicKey() string {
+	return hex.EncodeToString(id.key.Public().(ed25519.PublicKey))
+}
+
+// loadIdentityKey reads the hex-encoded seed of an ed25519 key from path, or
+// generates a key and saves it there if the file does not exist.
+func loadIdentityKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+		return key, err
+	} else if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not a hex-encoded ed25519 seed", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// loadTrustedKeys reads hex-encoded ed25519 public keys from path, one per
+// line. Empty lines and lines starting with # are ignored.
+func loadTrustedKeys(path string) ([]ed25519.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := []ed25519.PublicKey{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := hex.DecodeString(line)
+		if err != nil || len(k) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: not a hex-encoded ed25519 public key", path, n)
+		}
+		keys = append(keys, k)
+	}
+	return keys, sc.Err()
+}
+
+// verifyPeer accepts the certificate chain of a peer if its leaf carries a
+// trusted key. TLS has already checked that the peer holds the private key.
+func (id *identity) verifyPeer(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errUntrustedPeer
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return errUntrustedPeer
+	}
+	if _, there := id.trusted[hex.EncodeToString(pub)]; !there {
+		return errUntrustedPeer
+	}
+	return nil
+}
+
+func (id *identity) tlsConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{id.cert},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// certificates are self-signed; verifyPeer checks the key instead
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: id.verifyPeer,
+	}
+}
+
+// secure runs the TLS handshake over conn, as the client if dialed. It
+// returns the secured connection, the salts of our encoder and of our
+// decoder, and the public key of the peer.
+func (id *identity) secure(conn net.Conn, dialed bool) (net.Conn, [ldpc.SaltSize]byte, [ldpc.SaltSize]byte, string, error) {
+	var encoderKey, decoderKey [ldpc.SaltSize]byte
+	var tc *tls.Conn
+	if dialed {
+		tc = tls.Client(conn, id.tlsConfig())
+	} else {
+		tc = tls.Server(conn, id.tlsConfig())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, encoderKey, decoderKey, "", err
+	}
+	st := tc.ConnectionState()
+	peer := hex.EncodeToString(st.PeerCertificates[0].PublicKey.(ed25519.PublicKey))
+	client, err := st.ExportKeyingMaterial(saltExporterLabel, []byte("client"), ldpc.SaltSize)
+	if err != nil {
+		return nil, encoderKey, decoderKey, "", err
+	}
+	server, err := st.ExportKeyingMaterial(saltExporterLabel, []byte("server"), ldpc.SaltSize)
+	if err != nil {
+		return nil, encoderKey, decoderKey, "", err
+	}
+	if dialed {
+		copy(encoderKey[:], client)
+		copy(decoderKey[:], server)
+	} else {
+		copy(encoderKey[:], server)
+		copy(decoderKey[:], client)
+	}
+	return tc, encoderKey, decoderKey, peer, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+type testNode struct {
+	*controller
+	key ed25519.PrivateKey
+}
+
+func newTestNode(t *testing.T) testNode {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testNode{&controller{}, key}
+}
+
+func (n testNode) trust(t *testing.T, peers ...testNode) {
+	t.Helper()
+	keys := []ed25519.PublicKey{}
+	for _, p := range peers {
+		keys = append(keys, p.key.Public().(ed25519.PublicKey))
+	}
+	id, err := newIdentity(n.key, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.identity = id
+}
+
+type handshakeOutcome struct {
+	t        *streamTransport
+	enc, dec [ldpc.SaltSize]byte
+	err      error
+}
+
+// connect runs the handshake between a, which dials, and b over the two ends
+// of a connection.
+func connect(a, b testNode, ca, cb net.Conn) (handshakeOutcome, handshakeOutcome) {
+	res := make(chan handshakeOutcome)
+	go func() {
+		t, enc, dec, err := b.handshake("a", cb, false)
+		if err != nil {
+			cb.Close()
+		}
+		res <- handshakeOutcome{t, enc, dec, err}
+	}()
+	t, enc, dec, err := a.handshake("b", ca, true)
+	if err != nil {
+		ca.Close()
+	}
+	ra := handshakeOutcome{t, enc, dec, err}
+	return ra, <-res
+}
+
+func TestSecureSession(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	a.trust(t, b)
+	b.trust(t, a)
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	ra, rb := connect(a, b, ca, cb)
+	if ra.err != nil || rb.err != nil {
+		t.Fatal(ra.err, rb.err)
+	}
+	if ra.enc != rb.dec || ra.dec != rb.enc || ra.enc == ra.dec {
+		t.Fatal("salts not derived consistently")
+	}
+	go func() {
+		for i := 0; i < 10; i++ {
+			ra.t.writeCodeword(testCodeword(i))
+		}
+		ra.t.flush()
+	}()
+	for i := 0; i < 10; i++ {
+		cw, err := rb.t.readCodeword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cw.Symbol, testCodeword(i).Symbol) {
+			t.Fatalf("codeword %d corrupted", i)
+		}
+	}
+}
+
+// tcpPair returns the two ends of a loopback TCP connection. Unlike
+// net.Pipe, it buffers writes, so that a side failing the handshake does not
+// block on sending its alert while the other side is writing too.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ca, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ca.Close()
+		cb.Close()
+	})
+	return ca, cb
+}
+
+func TestSecureSessionRejected(t *testing.T) {
+	a, b, c := newTestNode(t), newTestNode(t), newTestNode(t)
+	a.trust(t, b)
+	b.trust(t, c)
+	ca, cb := tcpPair(t)
+	ra, rb := connect(a, b, ca, cb)
+	if ra.err == nil || rb.err == nil {
+		t.Error("untrusted peer accepted")
+	}
+
+	// b trusts a, but a does not trust b
+	a.trust(t)
+	b.trust(t, a)
+	ca, cb = tcpPair(t)
+	ra, rb = connect(a, b, ca, cb)
+	if ra.err == nil || rb.err == nil {
+		t.Error("untrusted peer accepted")
+	}
+
+	// a plaintext peer
+	plain := testNode{&controller{}, nil}
+	ca, cb = tcpPair(t)
+	ra, rb = connect(plain, b, ca, cb)
+	if ra.err == nil || rb.err == nil {
+		t.Error("plaintext peer accepted")
+	}
+}
+
+// tamperingConn flips the last bit of what it writes once armed. TLS writes
+// a record at a time, so that the bit is in the authentication tag.
+type tamperingConn struct {
+	net.Conn
+	armed atomic.Bool
+}
+
+func (c *tamperingConn) Write(p []byte) (int, error) {
+	if len(p) > 0 && c.armed.Load() {
+		q := append([]byte{}, p...)
+		q[len(q)-1] ^= 1
+		p = q
+	}
+	return c.Conn.Write(p)
+}
+
+func TestSecureSessionTampered(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	a.trust(t, b)
+	b.trust(t, a)
+	ca, cb := net.Pipe()
+	defer cb.Close()
+	tc := &tamperingConn{Conn: ca}
+	defer tc.Close()
+	ra, rb := connect(a, b, tc, cb)
+	if ra.err != nil || rb.err != nil {
+		t.Fatal(ra.err, rb.err)
+	}
+	tc.armed.Store(true)
+	// net.Pipe does not buffer; take the alert b sends back
+	go ra.t.readCodeword()
+	go func() {
+		ra.t.writeCodeword(testCodeword(1))
+		ra.t.flush()
+	}()
+	if _, err := rb.t.readCodeword(); err == nil || err == io.EOF {
+		t.Fatalf("tampered frame accepted: %v", err)
+	}
+}
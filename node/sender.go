@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/gob"
 	"github.com/yangl1996/rateless-set-reconcile/ldpc"
 	"log"
 	"time"
@@ -9,7 +8,7 @@ import (
 
 type sender struct {
 	peerId string
-	tx                   *gob.Encoder
+	tx                   codewordWriter
 	encoder              *ldpc.Encoder
 	cwRate               float64 // codeword sending rate in s^-1
 	rateIncreaseConstant float64
@@ -20,26 +19,42 @@ type sender struct {
 	peerLoss       <-chan int
 	ourLoss        <-chan int
 	newTransaction <-chan *ldpc.Transaction
+	done <-chan struct{} // closed when the peer is disconnected
 	droppedCodewords int
 	credit float64
+	metrics *peerMetrics
 }
 
 func (s *sender) sendCodewords(ch <-chan Codeword) error {
 	for cw := range ch {
-		err := s.tx.Encode(cw)
+		err := s.tx.writeCodeword(cw)
 		if err != nil {
 			return err
 		}
+		// let the transport batch codewords that are already waiting
+		if len(ch) == 0 {
+			err = s.tx.flush()
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (s *sender) loop(ch chan<- Codeword) error {
+// loop produces codewords into ch until the peer is disconnected, and then
+// closes ch.
+func (s *sender) loop(ch chan<- Codeword) {
+	defer close(ch)
 	sendTicker := time.NewTicker(10 * time.Millisecond)
+	defer sendTicker.Stop()
 	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 	log.Println("sender started")
 	for {
 		select {
+		case <-s.done:
+			return
 		case l := <-s.peerLoss:
 			s.cwRate += s.rateIncreaseConstant * float64(l)
 		case l := <-s.ourLoss:
@@ -54,6 +69,7 @@ func (s *sender) loop(ch chan<- Codeword) error {
 				nc := Codeword{s.encoder.ProduceCodeword(), s.accumLoss, time.Now().UnixMicro()}
 				select {
 				case ch <- nc:
+					s.metrics.codewordsSent.Add(1)
 					s.accumLoss = 0
 					s.cwRate -= s.rateDecreaseConstant
 					if s.cwRate < s.minRate {
@@ -62,11 +78,12 @@ func (s *sender) loop(ch chan<- Codeword) error {
 				default:
 					// do not reset accumLoss now that the codeword is skipped
 					s.droppedCodewords += 1
+					s.metrics.codewordsDropped.Add(1)
 				}
 			}
 		case <-ticker.C:
+			s.metrics.setRate(s.cwRate)
 			log.Printf("peer %s codeword rate %.2f dropped %d\n", s.peerId, s.cwRate, s.droppedCodewords)
 		}
 	}
-	panic("unreachable")
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+)
+
+// codewordWriter carries codewords to a peer. Codewords may be buffered
+// until flush.
+type codewordWriter interface {
+	writeCodeword(cw Codeword) error
+	flush() error
+}
+
+// codewordReader carries codewords from a peer.
+type codewordReader interface {
+	readCodeword() (Codeword, error)
+}
+
+// codewordTransport carries codewords both ways, and is closed when either
+// way fails.
+type codewordTransport interface {
+	codewordWriter
+	codewordReader
+	io.Closer
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// The UDP transport carries codewords in datagrams, so that a lost packet
+// only costs the codewords in it instead of holding up the ones behind it as
+// over TCP. Every packet starts with a header
+//
+//	magic (2 bytes) | version (1) | type (1) | session ID (8)
+//
+// where the session ID is drawn at random by the sender of the packet when it
+// starts talking to a peer. The body depends on the type:
+//
+//	hello: key (ldpc.SaltSize); sent until the peer answers with its own
+//	data:  sequence number (8) | total loss (uvarint) | codewords
+//	ack:   highest sequence number received (8) | packets received (8)
+//
+// with codewords serialized by appendCodeword. Data packets hold as many
+// codewords as fit in the MTU. The decoding loss piggybacked on codewords is
+// sent as a running total, so that the reports in lost packets are not lost
+// as well. Acks report the network loss, which counts the codewords in the
+// lost packets, estimated from the average number of codewords in a packet,
+// toward the decoding loss of the peer, so that the codeword rate makes up
+// for them as for codewords the peer failed to decode.
+//
+// A hello from an unknown address starts a session, up to udpMaxSessions of
+// them. A hello with a new session ID from a known address means that the
+// peer has restarted, but only once the old session ID has gone quiet, lest
+// a spoofed hello tear down a live session.
+var udpMagic = [2]byte{'R', 'S'}
+
+const (
+	udpVersion        = 2
+	udpHeaderSize     = 12
+	udpDataHeaderSize = udpHeaderSize + 8 + binary.MaxVarintLen64
+	udpMaxPacketSize  = 65507
+	// a session is closed when nothing arrives from the peer for this long;
+	// peers send at least a codeword a second
+	udpIdleTimeout = 10 * time.Second
+	// a hello with a new session ID restarts a session that has been quiet
+	// for this long
+	udpRestartTimeout = 2 * time.Second
+	udpMaxSessions    = 1024
+)
+
+const (
+	udpHello byte = iota + 1
+	udpData
+	udpAck
+)
+
+var errMalformedPacket = errors.New("malformed packet")
+
+// udpEndpoint multiplexes sessions with all peers over one socket.
+type udpEndpoint struct {
+	conn        net.PacketConn
+	mtu         int
+	maxSessions int
+	onSession   func(s *udpSession) // called once the keys of s are exchanged
+
+	lock     sync.Mutex
+	sessions map[string]*udpSession
+	dialed   map[string]net.Addr // peers to keep a session with
+}
+
+func newUDPEndpoint(conn net.PacketConn, mtu int, onSession func(s *udpSession)) *udpEndpoint {
+	return &udpEndpoint{
+		conn:        conn,
+		mtu:         mtu,
+		maxSessions: udpMaxSessions,
+		onSession:   onSession,
+		sessions:    make(map[string]*udpSession),
+		dialed:      make(map[string]net.Addr),
+	}
+}
+
+// udpSession is the transport to one peer.
+type udpSession struct {
+	ep     *udpEndpoint
+	addr   net.Addr
+	id     uint64 // session ID of our packets
+	ourKey [ldpc.SaltSize]byte
+	dialer bool // whether we send hellos until the peer answers
+	inbox  chan Codeword
+
+	lock          sync.Mutex
+	established   bool
+	peerId        uint64 // session ID of the packets of the peer
+	peerKey       [ldpc.SaltSize]byte
+	lastHeard     time.Time
+	highest       uint64 // highest sequence number received
+	received      uint64 // data packets received
+	ackedReceived uint64 // data packets received when we last sent an ack
+	peerLossTotal uint64 // running total of the loss reported by the peer
+	sent          uint64 // data packets sent
+	peerHighest   uint64 // highest sequence number acked by the peer
+	peerReceived  uint64 // data packets the peer has received
+	peerLost      uint64 // data packets the peer has reported lost
+	cwSent        uint64 // codewords sent in data packets
+	netLoss       int    // codewords lost in the network, not yet reported to the sender
+	dropped       int    // codewords dropped as the inbox is full
+	malformed     int
+	closed        bool
+
+	// used by the sending goroutine only
+	seq       uint64
+	lossTotal uint64
+	body      []byte
+	ncw       int
+}
+
+func (e *udpEndpoint) newSession(addr net.Addr, dialer bool) *udpSession {
+	s := &udpSession{
+		ep:     e,
+		addr:   addr,
+		id:     rand.Uint64(),
+		dialer: dialer,
+		inbox:  make(chan Codeword, 1000),
+	}
+	rand.Read(s.ourKey[:])
+	e.sessions[addr.String()] = s
+	return s
+}
+
+// dial starts a session with the peer at addr, and starts a new one
+// whenever it is closed.
+func (e *udpEndpoint) dial(addr string) error {
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	e.dialed[a.String()] = a
+	s := e.newSession(a, true)
+	e.lock.Unlock()
+	return s.sendHello()
+}
+
+// serve reads packets until the socket is closed.
+func (e *udpEndpoint) serve() error {
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, addr, err := e.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		e.handlePacket(addr, buf[:n])
+	}
+}
+
+// maintain sends hellos to peers that have not answered, acks to peers that
+// have sent data, and logs the stats of each session every second. It closes
+// idle sessions, and starts new ones with dialed peers in their place.
+func (e *udpEndpoint) maintain() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	for n := 1; ; n++ {
+		<-ticker.C
+		e.lock.Lock()
+		for key, a := range e.dialed {
+			if _, there := e.sessions[key]; !there {
+				e.newSession(a, true)
+			}
+		}
+		sessions := make([]*udpSession, 0, len(e.sessions))
+		for _, s := range e.sessions {
+			sessions = append(sessions, s)
+		}
+		e.lock.Unlock()
+		for _, s := range sessions {
+			s.maintain(n%10 == 0)
+		}
+	}
+}
+
+func (e *udpEndpoint) handlePacket(addr net.Addr, pkt []byte) {
+	if len(pkt) < udpHeaderSize || pkt[0] != udpMagic[0] || pkt[1] != udpMagic[1] || pkt[2] != udpVersion {
+		return
+	}
+	typ := pkt[3]
+	sid := binary.LittleEndian.Uint64(pkt[4:12])
+	body := pkt[udpHeaderSize:]
+	e.lock.Lock()
+	s, there := e.sessions[addr.String()]
+	if !there {
+		if typ != udpHello || len(e.sessions) >= e.maxSessions {
+			e.lock.Unlock()
+			return
+		}
+		s = e.newSession(addr, false)
+	}
+	e.lock.Unlock()
+	if typ == udpHello && s.restartedBy(sid) {
+		log.Printf("peer %s started a new udp session\n", addr)
+		s.Close()
+		e.lock.Lock()
+		s = e.newSession(addr, s.dialer)
+		e.lock.Unlock()
+	}
+	switch typ {
+	case udpHello:
+		s.handleHello(sid, body)
+	case udpData:
+		s.handleData(sid, body)
+	case udpAck:
+		s.handleAck(sid, body)
+	}
+}
+
+func (s *udpSession) appendHeader(buf []byte, typ byte) []byte {
+	buf = append(buf, udpMagic[0], udpMagic[1], udpVersion, typ)
+	return binary.LittleEndian.AppendUint64(buf, s.id)
+}
+
+func (s *udpSession) send(pkt []byte) error {
+	_, err := s.ep.conn.WriteTo(pkt, s.addr)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		// treat it as a lost packet
+		log.Printf("peer %s error sending udp packet: %v\n", s.addr, err)
+		return nil
+	}
+	return err
+}
+
+func (s *udpSession) sendHello() error {
+	pkt := s.appendHeader(nil, udpHello)
+	pkt = append(pkt, s.ourKey[:]...)
+	return s.send(pkt)
+}
+
+// restartedBy returns whether a hello with session ID sid means that the
+// peer has started over, e.g., after a restart. It does not while the old
+// session ID is still heard from.
+func (s *udpSession) restartedBy(sid uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.established && sid != s.peerId && time.Since(s.lastHeard) > udpRestartTimeout
+}
+
+func (s *udpSession) handleHello(sid uint64, body []byte) {
+	if len(body) != ldpc.SaltSize {
+		s.lock.Lock()
+		s.malformed += 1
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Lock()
+	newly := false
+	if !s.established {
+		s.established = true
+		s.peerId = sid
+		copy(s.peerKey[:], body)
+		newly = true
+	} else if sid != s.peerId {
+		s.lock.Unlock()
+		return
+	}
+	s.lastHeard = time.Now()
+	s.lock.Unlock()
+	// the peer sends hellos until it gets ours
+	if !s.dialer {
+		s.sendHello()
+	}
+	if newly {
+		log.Printf("key exchanged with peer %s, our key %x, peer key %x\n", s.addr, s.ourKey[:], s.peerKey[:])
+		go s.ep.onSession(s)
+	}
+}
+
+func (s *udpSession) handleData(sid uint64, body []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.established || sid != s.peerId || s.closed {
+		return
+	}
+	seq, lossTotal, cws, err := parseData(body)
+	if err != nil {
+		s.malformed += 1
+		return
+	}
+	s.lastHeard = time.Now()
+	s.received += 1
+	if seq > s.highest {
+		s.highest = seq
+	}
+	// reports in reordered packets are already covered by later ones
+	if lossTotal > s.peerLossTotal && len(cws) != 0 {
+		cws[0].Loss = int(lossTotal - s.peerLossTotal)
+		s.peerLossTotal = lossTotal
+	}
+	for _, cw := range cws {
+		select {
+		case s.inbox <- cw:
+		default:
+			s.dropped += 1
+		}
+	}
+}
+
+func (s *udpSession) handleAck(sid uint64, body []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.established || sid != s.peerId {
+		return
+	}
+	if len(body) != 16 {
+		s.malformed += 1
+		return
+	}
+	s.lastHeard = time.Now()
+	highest := binary.LittleEndian.Uint64(body[0:8])
+	received := binary.LittleEndian.Uint64(body[8:16])
+	// acks may be reordered as well
+	if received <= s.peerReceived || received > highest+1 {
+		return
+	}
+	s.peerReceived = received
+	s.peerHighest = highest
+	// packets reordered in the network may be counted as lost and then
+	// arrive, so only count the loss beyond what has been counted
+	if lost := highest + 1 - received; lost > s.peerLost && s.sent != 0 {
+		s.netLoss += int((lost - s.peerLost) * s.cwSent / s.sent)
+		s.peerLost = lost
+	}
+}
+
+func (s *udpSession) maintain(report bool) {
+	s.lock.Lock()
+	if s.established && time.Since(s.lastHeard) > udpIdleTimeout {
+		s.lock.Unlock()
+		log.Printf("peer %s udp session idle, closing\n", s.addr)
+		s.Close()
+		return
+	}
+	established := s.established
+	var ack []byte
+	if established && s.received != s.ackedReceived {
+		ack = s.appendHeader(nil, udpAck)
+		ack = binary.LittleEndian.AppendUint64(ack, s.highest)
+		ack = binary.LittleEndian.AppendUint64(ack, s.received)
+		s.ackedReceived = s.received
+	}
+	if report && established {
+		log.Printf("peer %s udp packets sent %d acked %d lost %d, codewords dropped %d, malformed packets %d\n", s.addr, s.sent, s.peerReceived, s.peerLost, s.dropped, s.malformed)
+	}
+	s.lock.Unlock()
+	if !established && s.dialer {
+		s.sendHello()
+	}
+	if ack != nil {
+		s.send(ack)
+	}
+}
+
+func parseData(body []byte) (uint64, uint64, []Codeword, error) {
+	if len(body) < 8 {
+		return 0, 0, nil, errMalformedPacket
+	}
+	seq := binary.LittleEndian.Uint64(body[0:8])
+	r := bytes.NewReader(body[8:])
+	lossTotal, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, errMalformedPacket
+	}
+	cws := []Codeword{}
+	for r.Len() != 0 {
+		cw, err := readCodeword(r)
+		if err != nil {
+			return 0, 0, nil, errMalformedPacket
+		}
+		cws = append(cws, cw)
+	}
+	return seq, lossTotal, cws, nil
+}
+
+// writeCodeword adds cw to the data packet being built, and sends the packet
+// first if cw does not fit in it. A codeword larger than the MTU by itself
+// is sent in its own packet, which the network may fragment.
+func (s *udpSession) writeCodeword(cw Codeword) error {
+	size := codewordSize(cw)
+	if s.ncw != 0 && udpDataHeaderSize+len(s.body)+size > s.ep.mtu {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.lossTotal += uint64(cw.Loss)
+	s.body = appendCodeword(s.body, cw)
+	s.ncw += 1
+	return nil
+}
+
+// flush sends the data packet being built, if any.
+func (s *udpSession) flush() error {
+	if s.ncw == 0 {
+		return nil
+	}
+	pkt := make([]byte, 0, udpDataHeaderSize+len(s.body))
+	pkt = s.appendHeader(pkt, udpData)
+	pkt = binary.LittleEndian.AppendUint64(pkt, s.seq)
+	pkt = binary.AppendUvarint(pkt, s.lossTotal)
+	pkt = append(pkt, s.body...)
+	s.seq += 1
+	ncw := s.ncw
+	s.body = s.body[:0]
+	s.ncw = 0
+	s.lock.Lock()
+	s.sent += 1
+	s.cwSent += uint64(ncw)
+	s.lock.Unlock()
+	return s.send(pkt)
+}
+
+// readCodeword returns the next codeword from the peer, with the codewords
+// lost in the network since the last one added to its loss.
+func (s *udpSession) readCodeword() (Codeword, error) {
+	cw, ok := <-s.inbox
+	if !ok {
+		return cw, io.EOF
+	}
+	s.lock.Lock()
+	cw.Loss += s.netLoss
+	s.netLoss = 0
+	s.lock.Unlock()
+	return cw, nil
+}
+
+// Close stops delivering codewords from the peer and forgets the session,
+// so that packets from the peer are handled as from a new one.
+func (s *udpSession) Close() error {
+	s.ep.lock.Lock()
+	if s.ep.sessions[s.addr.String()] == s {
+		delete(s.ep.sessions, s.addr.String())
+	}
+	s.ep.lock.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.inbox)
+	}
+	return nil
+}
+
+// lossyPacketConn drops outgoing packets with probability loss, to test the
+// transport on loopback.
+type lossyPacketConn struct {
+	net.PacketConn
+	loss float64
+
+	lock sync.Mutex
+	rng  *rand.Rand
+}
+
+func newLossyPacketConn(conn net.PacketConn, loss float64) *lossyPacketConn {
+	return &lossyPacketConn{
+		PacketConn: conn,
+		loss:       loss,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (c *lossyPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.lock.Lock()
+	drop := c.rng.Float64() < c.loss
+	c.lock.Unlock()
+	if drop {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
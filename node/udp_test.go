@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/yangl1996/rateless-set-reconcile/ldpc"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenLoopback(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func testCodeword(i int) Codeword {
+	cw := Codeword{Codeword: &ldpc.Codeword{}, UnixMicro: int64(i)}
+	// symbols of various sizes, as codewords are as long as their longest member
+	cw.Symbol = make(ldpc.TransactionData, 2+i*37%(4*ldpc.TxSize))
+	cw.Symbol[0] = byte(i)
+	cw.Symbol[len(cw.Symbol)-1] = byte(i >> 8)
+	for j := 0; j < i%60; j++ {
+		cw.Members = append(cw.Members, uint32(i*100+j))
+	}
+	return cw
+}
+
+// TestUDPLoopback sends codewords over a lossy loopback link and checks that
+// the ones that arrive are intact, and that loss reports are not lost with
+// the packets carrying them.
+func TestUDPLoopback(t *testing.T) {
+	sessions := make(chan *udpSession, 2)
+	onSession := func(s *udpSession) {
+		sessions <- s
+	}
+	a := newUDPEndpoint(newLossyPacketConn(listenLoopback(t), 0.2), 1400, onSession)
+	b := newUDPEndpoint(listenLoopback(t), 1400, onSession)
+	go a.serve()
+	go b.serve()
+	go a.maintain()
+	go b.maintain()
+	if err := a.dial(b.conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	var sa, sb *udpSession
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-sessions:
+			if s.ep == a {
+				sa = s
+			} else {
+				sb = s
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("keys not exchanged")
+		}
+	}
+	if sa.ourKey != sb.peerKey || sb.ourKey != sa.peerKey {
+		t.Fatal("keys mismatch")
+	}
+
+	// garbage from a port scanner is ignored
+	junk := listenLoopback(t)
+	junk.WriteTo([]byte("GET / HTTP/1.1\r\n\r\n"), b.conn.LocalAddr())
+	junk.WriteTo(append([]byte{'R', 'S', udpVersion, udpData}, make([]byte, 40)...), b.conn.LocalAddr())
+
+	const n = 2000
+	var received, lossReceived int
+	done := make(chan error)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case cw := <-sb.inbox:
+				exp := testCodeword(int(cw.UnixMicro))
+				if !bytes.Equal(cw.Symbol, exp.Symbol) || len(cw.Members) != len(exp.Members) {
+					t.Errorf("codeword %d corrupted", cw.UnixMicro)
+					return
+				}
+				for j := range cw.Members {
+					if cw.Members[j] != exp.Members[j] {
+						t.Errorf("codeword %d corrupted", cw.UnixMicro)
+						return
+					}
+				}
+				received += 1
+				lossReceived += cw.Loss
+				if cw.UnixMicro == n-1 {
+					return
+				}
+			case <-time.After(500 * time.Millisecond):
+				// the last packet was lost
+				return
+			}
+		}
+	}()
+	lossSent := 0
+	for i := 0; i < n; i++ {
+		cw := testCodeword(i)
+		cw.Loss = i % 3
+		lossSent += cw.Loss
+		if err := sa.writeCodeword(cw); err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			if err := sa.flush(); err != nil {
+				t.Fatal(err)
+			}
+			// pace the packets like the sender does, lest the socket
+			// buffer of the receiver overflow
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	if err := sa.flush(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if received == 0 || received == n {
+		t.Fatalf("received %d of %d codewords with 20%% loss", received, n)
+	}
+	if lossReceived > lossSent || lossReceived < lossSent-10 {
+		t.Errorf("received loss reports totaling %d, sent %d", lossReceived, lossSent)
+	}
+	time.Sleep(300 * time.Millisecond)
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.peerReceived == 0 || sa.peerReceived > sa.sent {
+		t.Errorf("acked %d of %d packets", sa.peerReceived, sa.sent)
+	}
+	// the codewords in lost packets count toward the loss reported to the
+	// sender, estimated from the average number of codewords in a packet,
+	// so the count may be off by a few either way
+	lost := n - received
+	if sa.netLoss > lost*5/4 || sa.netLoss < lost*3/4 {
+		t.Errorf("acks report %d codewords lost, %d were", sa.netLoss, lost)
+	}
+	t.Logf("received %d of %d codewords in %d packets", received, n, sa.sent)
+}
+
+func helloPacket(sid uint64) []byte {
+	pkt := append([]byte{'R', 'S', udpVersion, udpHello}, binary.LittleEndian.AppendUint64(nil, sid)...)
+	return append(pkt, make([]byte, ldpc.SaltSize)...)
+}
+
+// TestUDPSessionLimits checks that hellos from unknown addresses start no
+// more than maxSessions sessions, and that a hello with a new session ID only
+// replaces a session that has gone quiet.
+func TestUDPSessionLimits(t *testing.T) {
+	sessions := make(chan *udpSession, 10)
+	onSession := func(s *udpSession) {
+		sessions <- s
+	}
+	a := newUDPEndpoint(listenLoopback(t), 1400, onSession)
+	b := newUDPEndpoint(listenLoopback(t), 1400, onSession)
+	go a.serve()
+	go b.serve()
+	if err := a.dial(b.conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	var sb *udpSession
+	for sb == nil {
+		select {
+		case s := <-sessions:
+			if s.ep == b {
+				sb = s
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("keys not exchanged")
+		}
+	}
+
+	// a spoofed hello does not tear down a live session
+	aAddr := a.conn.LocalAddr()
+	b.handlePacket(aAddr, helloPacket(sb.peerId+1))
+	b.lock.Lock()
+	live := b.sessions[aAddr.String()] == sb
+	b.lock.Unlock()
+	if !live {
+		t.Fatal("hello with a new session ID replaced a live session")
+	}
+	// but a restarted peer gets a new session once the old one is quiet
+	sb.lock.Lock()
+	sb.lastHeard = time.Now().Add(-2 * udpRestartTimeout)
+	sb.lock.Unlock()
+	b.handlePacket(aAddr, helloPacket(sb.peerId+1))
+	b.lock.Lock()
+	restarted := b.sessions[aAddr.String()] != sb
+	b.lock.Unlock()
+	if !restarted {
+		t.Fatal("hello with a new session ID did not replace a quiet session")
+	}
+
+	b.lock.Lock()
+	b.maxSessions = len(b.sessions) + 1
+	b.lock.Unlock()
+	for port := 1; port <= 3; port++ {
+		b.handlePacket(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, helloPacket(uint64(port)))
+	}
+	b.lock.Lock()
+	n := len(b.sessions)
+	b.lock.Unlock()
+	if n != b.maxSessions {
+		t.Errorf("%d sessions, expected at most %d", n, b.maxSessions)
+	}
+}
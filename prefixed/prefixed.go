@@ -0,0 +1,61 @@
+// Package prefixed implements the symbols of variable-length transactions
+// shared by the ldpc and lt codecs. A symbol is the XOR of transactions, each
+// prefixed by its length (2 bytes, little endian) and padded with zeros to
+// the longest of them, so that it is as long as its longest member, and the
+// symbol of a single transaction can be trimmed back to the transaction.
+package prefixed
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+const (
+	MaxDataSize = 1<<16 - 1 // max length of data the prefix can hold
+	PrefixSize  = 2         // size of the length prefix
+)
+
+// Prefix returns data prefixed by its length. data must be at most
+// MaxDataSize bytes.
+func Prefix(data []byte) []byte {
+	s := make([]byte, PrefixSize+len(data))
+	binary.LittleEndian.PutUint16(s, uint16(len(data)))
+	copy(s[PrefixSize:], data)
+	return s
+}
+
+// XOR sets s to the XOR of s and s2, first padding s with zeros to the
+// length of s2 if it is shorter, and returns it.
+func XOR(s, s2 []byte) []byte {
+	if n := len(s2); len(s) < n {
+		if cap(s) >= n {
+			l := len(s)
+			s = s[:n]
+			tail := s[l:]
+			for i := range tail {
+				tail[i] = 0
+			}
+		} else {
+			grown := make([]byte, n)
+			copy(grown, s)
+			s = grown
+		}
+	}
+	subtle.XORBytes(s, s, s2)
+	return s
+}
+
+// Data returns the data in the symbol s of a single transaction, without the
+// prefix and the padding. If the prefix is corrupt, that is, s is shorter
+// than the prefix or than the length it holds, Data returns what s has after
+// the prefix and false.
+func Data(s []byte) ([]byte, bool) {
+	if len(s) < PrefixSize {
+		return nil, false
+	}
+	l := int(binary.LittleEndian.Uint16(s))
+	if PrefixSize+l > len(s) {
+		return s[PrefixSize:], false
+	}
+	return s[PrefixSize : PrefixSize+l], true
+}
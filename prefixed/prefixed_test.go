@@ -0,0 +1,36 @@
+package prefixed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXORAndData(t *testing.T) {
+	short := Prefix([]byte("short"))
+	long := Prefix([]byte("a longer transaction"))
+	var s []byte
+	s = XOR(XOR(s, short), long)
+	if len(s) != len(long) {
+		t.Error("symbol not padded to the longest member")
+	}
+	s = XOR(s, long)
+	if d, ok := Data(s); !ok || !bytes.Equal(d, []byte("short")) {
+		t.Error("incorrect data after peeling")
+	}
+	// reusing the capacity must not leave stale bytes in the padding
+	s = XOR(s[:0], short)
+	s = XOR(s, long)
+	s = XOR(s, long)
+	if d, ok := Data(s); !ok || !bytes.Equal(d, []byte("short")) {
+		t.Error("stale bytes in the padding")
+	}
+}
+
+func TestCorruptPrefix(t *testing.T) {
+	if _, ok := Data([]byte{1}); ok {
+		t.Error("short symbol not reported")
+	}
+	if d, ok := Data([]byte{0xff, 0xff, 1}); ok || len(d) != 1 {
+		t.Error("corrupt length prefix not clamped")
+	}
+}
@@ -2,7 +2,8 @@ package riblt
 
 import (
 	"encoding/binary"
-	"math"
+	"fmt"
+	"runtime"
 	"testing"
 	"github.com/dchest/siphash"
 )
@@ -31,24 +32,6 @@ func newTestSymbol(i uint64) *testSymbol {
 	return &data
 }
 
-type testDegreeSequence struct {
-	count int
-}
-
-func (t *testDegreeSequence) Reset() {
-	t.count = 0
-}
-
-func (t *testDegreeSequence) NextThreshold() uint64 {
-	var th uint64
-	th = math.MaxUint64
-	if t.count != 0 {
-		th = uint64(float64(th) / (1 + float64(t.count)/2))
-	}
-	t.count += 1
-	return th
-}
-
 func TestEncodeAndDecode(t *testing.T) {
 	enc := Encoder[*testSymbol]{}
 	dec := Decoder[*testSymbol]{}
@@ -122,3 +105,34 @@ func BenchmarkEncoding(b *testing.B) {
 		}
     }
 }
+
+func BenchmarkParallelEncoding(b *testing.B) {
+	n := 100000
+	m := 15000
+	batch := 1000
+	data := []HashedSymbol[*testSymbol]{}
+	for j := 0; j < n; j++ {
+		s := newTestSymbol(uint64(j))
+		data = append(data, HashedSymbol[*testSymbol]{s, s.Hash()})
+	}
+	for np := 1; np <= runtime.NumCPU(); np *= 2 {
+		b.Run(fmt.Sprintf("partitions=%d", np), func(b *testing.B) {
+			enc := NewParallelEncoder[*testSymbol](np)
+			buf := make([]CodedSymbol[*testSymbol], batch)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// only measure the production of coded symbols, which
+				// is what the partitions parallelize
+				b.StopTimer()
+				enc.Reset()
+				for j := 0; j < n; j++ {
+					enc.AddHashedSymbol(data[j])
+				}
+				b.StartTimer()
+				for j := 0; j < m; j += batch {
+					enc.ProduceNextCodedSymbols(buf)
+				}
+			}
+		})
+	}
+}
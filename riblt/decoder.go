@@ -1,8 +1,24 @@
 package riblt
 
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
 type receivedSymbol[T Symbol[T]] struct {
 	CodedSymbol[T]
 	dirty bool
+	// missing is set for coded symbols not received yet, which were
+	// skipped by AddCodedSymbolAt. They hold the symbols to peel off them
+	// when they arrive, and applied is set once there is any, so that the
+	// sum is no longer the default value of T.
+	missing bool
+	applied bool
+}
+
+func (c CodedSymbol[T]) isZero() bool {
+	return c.count == 0 && c.checksum == 0
 }
 
 // TODO: the current decoder is optimized for rateless decoding. For the cases
@@ -11,19 +27,118 @@ type receivedSymbol[T Symbol[T]] struct {
 // we no longer need to insert symbol mappings for local and remote into the
 // heaps, which should save us some time.
 
+// pendingCorrection is a Correction whose index is beyond the coded symbols
+// received so far. The symbol is removed from coded symbols it maps to as
+// they arrive, until the index.
+type pendingCorrection[T Symbol[T]] struct {
+	symbol HashedSymbol[T]	// Hash is rehashed with the key
+	mapping randomMapping
+	until int
+}
+
 type Decoder[T Symbol[T]] struct {
-	cs []receivedSymbol[T]	// coded symbols received so far
+	cs []receivedSymbol[T]	// coded symbols received so far, except discarded ones
+	base int			// number of coded symbols discarded from the beginning of cs
 	local codingWindow[T]
 	window codingWindow[T]	// set of the symbols that the decoder already has
 	remote codingWindow[T]
+	corrections []pendingCorrection[T]
 	dirty []int
-	pending int			// number of symbols that are not pure
+	pending int			// number of coded symbols that are not zero
+	missing int			// number of coded symbols skipped by AddCodedSymbolAt and not received yet
+	key Key
+	seq DegreeSequence
+	budget int			// number of coded symbols to give up after; 0 for no limit
+	err error			// sticky error from an inconsistent coded symbol
+	sealed bool			// whether the local set may no longer change
+}
+
+var (
+	ErrBudgetExhausted = errors.New("coded symbol budget exhausted before decoding")
+	ErrDuplicateSymbol = errors.New("symbol recovered more than once")
+)
+
+// DecodeError is returned by TryDecode when decoding has failed, either
+// because the coded symbols received are inconsistent, or because the
+// decoder has received as many coded symbols as its budget without
+// decoding.
+type DecodeError struct {
+	Reason error		// ErrBudgetExhausted or ErrDuplicateSymbol
+	Received int		// number of coded symbols received, including missing ones
+	Missing int			// number of coded symbols skipped and not received
+	Pending int			// number of coded symbols that are not zero
+	// Stuck are the indices of coded symbols that look pure, i.e., have
+	// count 1 or -1, but whose checksum does not match the hash of their
+	// sum.
+	Stuck []int
+	// Overflow are the indices of coded symbols whose count is larger in
+	// absolute value than the number of coded symbols received, which an
+	// honest encoder is unlikely to produce once the budget is reached.
+	Overflow []int
+	// Duplicates are the hashes of symbols recovered more than once.
+	Duplicates []uint64
+}
+
+func (e DecodeError) Error() string {
+	if e.Missing != 0 {
+		return fmt.Sprintf("riblt: decoding failed after %d coded symbols (%d missing) with %d pending: %v", e.Received, e.Missing, e.Pending, e.Reason)
+	}
+	return fmt.Sprintf("riblt: decoding failed after %d coded symbols with %d pending: %v", e.Received, e.Pending, e.Reason)
+}
+
+func (e DecodeError) Unwrap() error {
+	return e.Reason
+}
+
+// SetKey sets the key to rehash symbol hashes with. It must match the key of
+// the encoder, must be called before any symbol is added, and is kept across
+// Reset.
+func (d *Decoder[T]) SetKey(k Key) {
+	d.key = k
+	d.local.key = k
+	d.window.key = k
+	d.remote.key = k
+}
+
+// SetBudget sets the number of coded symbols after which TryDecode gives up
+// and returns a DecodeError if the decoder has not decoded. A budget of 0,
+// the default, means no limit. Coded symbols skipped by AddCodedSymbolAt
+// count toward the budget. The budget is kept across Reset.
+func (d *Decoder[T]) SetBudget(n int) {
+	d.budget = n
+}
+
+// SetDegreeSequence sets the degree sequence to map symbols to coded
+// symbols with. It must match that of the encoder, must be called before
+// any symbol is added, and is kept across Reset.
+func (d *Decoder[T]) SetDegreeSequence(seq DegreeSequence) {
+	d.seq = seq
+	d.local.seq = seq
+	d.window.seq = seq
+	d.remote.seq = seq
 }
 
+// Decoded returns whether all received coded symbols have been peeled off
+// to empty. When coded symbols arrive out of order, coded symbol 0, which
+// every symbol is mapped to, must have been received as well. A transport
+// that loses coded symbols should make sure that it eventually arrives,
+// e.g., by sending it again until decoding finishes; otherwise decoding may
+// finish but is never reported. Once coded symbol 0 is discarded, it has
+// been received, and the coded symbol at the head of cs is a later one.
 func (d *Decoder[T]) Decoded() bool {
+	if d.base == 0 && len(d.cs) != 0 && d.cs[0].missing {
+		return false
+	}
 	return d.pending == 0
 }
 
+// Missing returns the number of coded symbols skipped by AddCodedSymbolAt
+// that have not been received. Lost coded symbols need not be received
+// for decoding to finish, except for coded symbol 0.
+func (d *Decoder[T]) Missing() int {
+	return d.missing
+}
+
 func (d *Decoder[T]) Local() []HashedSymbol[T] {
 	return d.local.symbols
 }
@@ -37,82 +152,336 @@ func (d *Decoder[T]) AddSymbol(s T) {
 	d.AddHashedSymbol(th)
 }
 
+// AddHashedSymbol adds s to the local set. It may be called after coded
+// symbols have been received, including for a symbol that has been decoded
+// as a remote symbol, which then no longer appears in Remote.
 func (d *Decoder[T]) AddHashedSymbol(s HashedSymbol[T]) {
-	d.window.addHashedSymbol(s)
+	d.checkSealed()
+	if len(d.cs) == 0 {
+		d.window.addHashedSymbol(s)
+		return
+	}
+	if len(d.remote.symbols) != 0 {
+		if sidx, there := d.remote.lookup(s.Hash); there {
+			// s has been peeled off as a remote symbol; it keeps being
+			// peeled off the same way as a local symbol
+			m := d.remote.mappings[sidx]
+			rs, kh := d.remote.removeSymbol(sidx)
+			d.window.addHashedSymbolWithMapping(rs, kh, m)
+			return
+		}
+	}
+	kh := d.key.rehash(s.Hash)
+	m := d.applyNewSymbol(s, kh, remove, 0, len(d.cs))
+	d.window.addHashedSymbolWithMapping(s, kh, m)
 }
 
-func (d *Decoder[T]) AddCodedSymbol(c CodedSymbol[T]) {
-	// scan through decoded symbols to peel off matching ones
-	c = d.window.applyWindow(c, remove)
-	c = d.remote.applyWindow(c, remove)
-	c = d.local.applyWindow(c, add)
-	if c.count == 0 && c.checksum == 0 {
-		// still insert the codeword in case a symbol added later causes it to become dirty
-		d.cs = append(d.cs, receivedSymbol[T]{c, false})
+func (d *Decoder[T]) RemoveSymbol(s T) error {
+	return d.RemoveHashedSymbol(HashedSymbol[T]{s, s.Hash()})
+}
+
+// RemoveHashedSymbol removes s from the local set. It may be called after
+// coded symbols have been received.
+func (d *Decoder[T]) RemoveHashedSymbol(s HashedSymbol[T]) error {
+	d.checkSealed()
+	sidx, there := d.window.lookup(s.Hash)
+	if !there {
+		return ErrNotFound
+	}
+	rs, kh := d.window.removeSymbol(sidx)
+	if len(d.local.symbols) != 0 {
+		if lidx, there := d.local.lookup(s.Hash); there {
+			// s has been decoded as missing from the remote set, so it
+			// has been added back to the coded symbols it was peeled
+			// off from, and keeps being so
+			d.local.removeSymbol(lidx)
+			return nil
+		}
+	}
+	// add s back to the coded symbols it has been peeled off from
+	d.applyNewSymbol(rs, kh, add, 0, len(d.cs))
+	return nil
+}
+
+// ApplyCorrection accounts for the removal of a symbol from the set of the
+// encoder after coded symbols including it have been produced.
+func (d *Decoder[T]) ApplyCorrection(c Correction[T]) {
+	d.checkSealed()
+	if c.Index == 0 {
 		return
+	}
+	var m randomMapping
+	var kh uint64
+	sidx, decoded := 0, false
+	if len(d.remote.symbols) != 0 {
+		sidx, decoded = d.remote.lookup(c.Symbol.Hash)
+	}
+	if decoded {
+		// the symbol has been decoded and is being peeled off all coded
+		// symbols, including those after the index that never included
+		// it; stop peeling it off and add it back to the latter
+		m = d.remote.mappings[sidx]
+		var rs HashedSymbol[T]
+		rs, kh = d.remote.removeSymbol(sidx)
+		if c.Index < len(d.cs) {
+			d.applyNewSymbol(rs, kh, add, c.Index, len(d.cs))
+		}
 	} else {
-		if c.count >= -1 && c.count <= 1 {
-			d.cs = append(d.cs, receivedSymbol[T]{c, true})
-			d.dirty = append(d.dirty, len(d.cs)-1)
+		kh = d.key.rehash(c.Symbol.Hash)
+		until := c.Index
+		if until > len(d.cs) {
+			until = len(d.cs)
+		}
+		m = d.applyNewSymbol(c.Symbol, kh, remove, 0, until)
+	}
+	// remove the symbol from coded symbols before the index that are yet to
+	// arrive
+	if int(m.lastIdx) < c.Index {
+		d.corrections = append(d.corrections, pendingCorrection[T]{HashedSymbol[T]{c.Symbol.Symbol, kh}, m, c.Index})
+	}
+}
+
+func (d *Decoder[T]) AddCodedSymbol(c CodedSymbol[T]) {
+	d.AddCodedSymbolAt(d.received(), c)
+}
+
+// AddCodedSymbolAt adds c as the coded symbol at index idx. Coded symbols
+// may arrive in any order and with gaps, e.g., when several encoders of
+// the same set each produce a range of coded symbols with
+// ProduceCodedSymbolRange. A coded symbol that has been added before is
+// ignored.
+func (d *Decoder[T]) AddCodedSymbolAt(idx int, c CodedSymbol[T]) {
+	if idx < d.received() {
+		d.fillMissing(idx, c)
+		return
+	}
+	for d.received() < idx {
+		d.appendMissing()
+	}
+	d.appendReceived(c)
+}
+
+// appendMissing appends a placeholder for the next coded symbol, and peels
+// off it the symbols that the decoder would have peeled off it on arrival.
+func (d *Decoder[T]) appendMissing() {
+	c, applied := d.peelNext(CodedSymbol[T]{})
+	d.cs = append(d.cs, receivedSymbol[T]{CodedSymbol: c, missing: true, applied: applied})
+	d.missing += 1
+}
+
+// fillMissing adds c as the coded symbol at idx, which may be missing.
+func (d *Decoder[T]) fillMissing(idx int, c CodedSymbol[T]) {
+	if idx < d.base {
+		return
+	}
+	rs := &d.cs[idx-d.base]
+	if !rs.missing {
+		return
+	}
+	if rs.applied {
+		// an empty c has the default value of T as its sum, which may
+		// not be XORed into
+		if c.isZero() {
+			c = rs.CodedSymbol
 		} else {
-			d.cs = append(d.cs, receivedSymbol[T]{c, false})
+			c.sum = c.sum.XOR(rs.sum)
+			c.count += rs.count
+			c.checksum ^= rs.checksum
 		}
+	}
+	*rs = receivedSymbol[T]{CodedSymbol: c}
+	d.missing -= 1
+	if c.count == 1 || c.count == -1 {
+		rs.dirty = true
+		d.dirty = append(d.dirty, idx)
+	}
+	if !c.isZero() {
 		d.pending += 1
-		return
 	}
 }
 
-func (d *Decoder[T]) applyNewSymbol(t HashedSymbol[T], direction int64) randomMapping {
-	m := randomMapping{t.Hash , 0}
-	for int(m.lastIdx) < len(d.cs) {
+func (d *Decoder[T]) appendReceived(c CodedSymbol[T]) {
+	idx := d.received()
+	c, _ = d.peelNext(c)
+	// still insert zero coded symbols in case a symbol added later causes
+	// them to become nonzero
+	if c.count == 1 || c.count == -1 {
+		d.cs = append(d.cs, receivedSymbol[T]{CodedSymbol: c, dirty: true})
+		d.dirty = append(d.dirty, idx)
+	} else {
+		d.cs = append(d.cs, receivedSymbol[T]{CodedSymbol: c})
+	}
+	if !c.isZero() {
+		d.pending += 1
+	}
+}
+
+// peelNext peels off c, the next coded symbol, the symbols removed from the
+// encoder, the local set, and the decoded symbols that map to it. It
+// returns whether there is any.
+func (d *Decoder[T]) peelNext(c CodedSymbol[T]) (CodedSymbol[T], bool) {
+	idx := d.received()
+	applied := d.window.touches() || d.remote.touches() || d.local.touches()
+	// peel off symbols removed from the encoder
+	for i := 0; i < len(d.corrections); {
+		pc := &d.corrections[i]
+		if int(pc.mapping.lastIdx) == idx {
+			c = c.apply(pc.symbol, remove)
+			pc.mapping.nextIndex(d.seq)
+			applied = true
+		}
+		if int(pc.mapping.lastIdx) >= pc.until {
+			l := len(d.corrections)-1
+			d.corrections[i] = d.corrections[l]
+			d.corrections[l] = pendingCorrection[T]{}
+			d.corrections = d.corrections[:l]
+		} else {
+			i += 1
+		}
+	}
+	// scan through decoded symbols to peel off matching ones
+	c = d.window.applyWindow(c, remove)
+	c = d.remote.applyWindow(c, remove)
+	c = d.local.applyWindow(c, add)
+	return c, applied
+}
+
+// applyNewSymbol applies t, whose hash rehashed with the key is checksum, to
+// the received coded symbols in [from, to) that it maps to. It returns the
+// mapping of t advanced to the first coded symbol at or after to.
+func (d *Decoder[T]) applyNewSymbol(t HashedSymbol[T], checksum uint64, direction int64, from, to int) randomMapping {
+	m := randomMapping{checksum, 0}
+	kt := HashedSymbol[T]{t.Symbol, checksum}
+	// discarded coded symbols were empty, so t was peeled off them already
+	if from < d.base {
+		from = d.base
+	}
+	for int(m.lastIdx) < from {
+		m.nextIndex(d.seq)
+	}
+	for int(m.lastIdx) < to {
 		cidx := int(m.lastIdx)
-		d.cs[cidx].CodedSymbol = d.cs[cidx].apply(t, direction)
-		c := d.cs[cidx]
-		if (!c.dirty) && c.count >= -1 && c.count <= 1 {
-			d.cs[cidx].dirty = true
+		cs := &d.cs[cidx-d.base]
+		if cs.missing {
+			cs.CodedSymbol = cs.apply(kt, direction)
+			cs.applied = true
+			m.nextIndex(d.seq)
+			continue
+		}
+		wasZero := cs.isZero()
+		cs.CodedSymbol = cs.apply(kt, direction)
+		if isZero := cs.isZero(); isZero != wasZero {
+			if isZero {
+				d.pending -= 1
+			} else {
+				d.pending += 1
+			}
+		}
+		if (!cs.dirty) && (cs.count == 1 || cs.count == -1) {
+			cs.dirty = true
 			d.dirty = append(d.dirty, cidx)
 		}
-		m.nextIndex()
+		m.nextIndex(d.seq)
 	}
 	return m
 }
 
-func (d *Decoder[T]) TryDecode() {
+// TryDecode peels off pure coded symbols until no more can be peeled. It
+// returns a DecodeError if a symbol is recovered more than once, which an
+// honest encoder never causes, or if the budget set by SetBudget has been
+// reached without decoding. The former error is returned by every later
+// call until Reset.
+func (d *Decoder[T]) TryDecode() error {
+	if d.err != nil {
+		return d.err
+	}
 	for didx := 0; didx < len(d.dirty); didx += 1 {
 		cidx := d.dirty[didx]
-		c := d.cs[cidx]
+		c := d.cs[cidx-d.base]
 		switch c.count {
 		case 1:
 			h := c.sum.Hash()
-			if h == c.checksum {
+			if kh := d.key.rehash(h); kh == c.checksum {
+				if d.recovered(h) {
+					return d.fail(h)
+				}
 				ns := HashedSymbol[T]{}
 				ns.Symbol = ns.Symbol.XOR(c.sum)	// force duplicate the symbol data
 				ns.Hash = h
-				m := d.applyNewSymbol(ns, remove)
-				d.remote.addHashedSymbolWithMapping(ns, m)
-				d.pending -= 1
+				m := d.applyNewSymbol(ns, kh, remove, 0, d.received())
+				d.remote.addHashedSymbolWithMapping(ns, kh, m)
 			}
 		case -1:
 			h := c.sum.Hash()
-			if h == c.checksum {
+			if kh := d.key.rehash(h); kh == c.checksum {
+				if d.recovered(h) {
+					return d.fail(h)
+				}
 				ns := HashedSymbol[T]{}
 				ns.Symbol = ns.Symbol.XOR(c.sum)	// force duplicate the symbol data
 				ns.Hash = h
-				m := d.applyNewSymbol(ns, add)
-				d.local.addHashedSymbolWithMapping(ns, m)
-				d.pending -= 1
-			}
-		case 0:
-			if c.checksum == 0 {
-				d.pending -= 1
+				m := d.applyNewSymbol(ns, kh, add, 0, d.received())
+				d.local.addHashedSymbolWithMapping(ns, kh, m)
 			}
 		// one may want to add a panic here when coded symbol is not of
-		// degree 1, -1 or 0, but this may be violated when a dirty coded symbol
+		// degree 1 or -1, but this may be violated when a dirty coded symbol
 		// is peeled before its turn
 		}
-		d.cs[cidx].dirty = false
+		d.cs[cidx-d.base].dirty = false
 	}
 	d.dirty = d.dirty[:0]
+	if d.sealed {
+		d.discard()
+	}
+	if d.budget > 0 && d.received() >= d.budget && !d.Decoded() {
+		return d.decodeError(ErrBudgetExhausted)
+	}
+	return nil
+}
+
+// recovered returns whether the symbol with hash h has been recovered from
+// the coded symbols, either as a remote or a local symbol.
+func (d *Decoder[T]) recovered(h uint64) bool {
+	if len(d.remote.symbols) != 0 {
+		if _, there := d.remote.lookup(h); there {
+			return true
+		}
+	}
+	if len(d.local.symbols) != 0 {
+		if _, there := d.local.lookup(h); there {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records that the symbol with hash h is recovered again. The dirty
+// coded symbols not yet visited stay dirty, which does not matter as the
+// decoder is stuck until Reset.
+func (d *Decoder[T]) fail(h uint64) error {
+	e := d.decodeError(ErrDuplicateSymbol)
+	e.Duplicates = []uint64{h}
+	d.err = e
+	return e
+}
+
+func (d *Decoder[T]) decodeError(reason error) DecodeError {
+	n := d.received()
+	e := DecodeError{Reason: reason, Received: n, Missing: d.missing, Pending: d.pending}
+	for i, c := range d.cs {
+		if c.missing {
+			continue
+		}
+		if c.count == 1 || c.count == -1 {
+			if d.key.rehash(c.sum.Hash()) != c.checksum {
+				e.Stuck = append(e.Stuck, d.base+i)
+			}
+		}
+		if c.count > int64(n) || -c.count > int64(n) {
+			e.Overflow = append(e.Overflow, d.base+i)
+		}
+	}
+	return e
 }
 
 func (d *Decoder[T]) Reset() {
@@ -122,9 +491,83 @@ func (d *Decoder[T]) Reset() {
 	if len(d.dirty) != 0 {
 		d.dirty = d.dirty[:0]
 	}
+	if len(d.corrections) != 0 {
+		d.corrections = d.corrections[:0]
+	}
 	d.local.reset()
 	d.remote.reset()
 	d.window.reset()
 	d.pending = 0
+	d.missing = 0
+	d.err = nil
+	d.base = 0
+	d.sealed = false
+}
+
+// received returns the number of coded symbols received, including the
+// discarded ones.
+func (d *Decoder[T]) received() int {
+	return d.base + len(d.cs)
+}
+
+// SealLocal tells the decoder that the local set will not change until
+// Reset: AddSymbol, RemoveSymbol and ApplyCorrection panic afterwards. In
+// exchange, the decoder discards coded symbols at the beginning of the
+// stream once they are peeled off to empty, and stops indexing the local
+// set, so that its memory stays bounded when the stream goes on after
+// decoding.
+func (d *Decoder[T]) SealLocal() {
+	d.sealed = true
+	d.window.index = nil
+	d.discard()
 }
 
+func (d *Decoder[T]) checkSealed() {
+	if d.sealed {
+		panic("changing the local set of a sealed decoder")
+	}
+}
+
+// discard drops the empty coded symbols at the beginning of cs. Appending
+// to cs reallocates it once it runs out of capacity, which frees the
+// dropped ones. It does nothing while there are dirty coded symbols, which
+// may be among them.
+func (d *Decoder[T]) discard() {
+	if len(d.dirty) != 0 {
+		return
+	}
+	n := 0
+	for n < len(d.cs) && !d.cs[n].missing && d.cs[n].isZero() {
+		d.cs[n] = receivedSymbol[T]{}	// do not keep the sum alive
+		n += 1
+	}
+	if n == len(d.cs) {
+		d.cs = d.cs[:0]
+	} else {
+		d.cs = d.cs[n:]
+	}
+	d.base += n
+}
+
+// MemoryStats is the memory used by a decoder.
+type MemoryStats struct {
+	CodedSymbols int		// coded symbols kept
+	Discarded int		// coded symbols discarded
+	Symbols int			// symbols kept, in the local set or decoded
+	Bytes int			// bytes allocated by the decoder, not counting memory referenced by symbols
+}
+
+func (d *Decoder[T]) MemoryStats() MemoryStats {
+	st := MemoryStats{
+		CodedSymbols: len(d.cs),
+		Discarded: d.base,
+	}
+	st.Bytes += cap(d.cs) * int(unsafe.Sizeof(receivedSymbol[T]{}))
+	st.Bytes += cap(d.dirty) * int(unsafe.Sizeof(int(0)))
+	st.Bytes += cap(d.corrections) * int(unsafe.Sizeof(pendingCorrection[T]{}))
+	for _, w := range []*codingWindow[T]{&d.window, &d.local, &d.remote} {
+		st.Symbols += len(w.symbols)
+		st.Bytes += w.memoryBytes()
+	}
+	return st
+}
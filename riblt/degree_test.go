@@ -0,0 +1,110 @@
+package riblt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var testDegreeSequences = map[string]DegreeSequence{
+	"harmonic2":  Harmonic(2),
+	"harmonic4":  Harmonic(4),
+	"harmonic1":  Harmonic(1),
+	"ibltprefix": IBLTPrefix{Cells: 32, Degree: 3},
+	"ibltprefix4": IBLTPrefix{Cells: 16, Degree: 2, Tail: Harmonic(4)},
+}
+
+func TestDefaultDegreeSequence(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	explicit := Encoder[*testSymbol]{}
+	explicit.SetDegreeSequence(Harmonic(2))
+	for i := 0; i < 1000; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+		explicit.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for i := 0; i < 2000; i++ {
+		if !equalCodedSymbols(enc.ProduceNextCodedSymbol(), explicit.ProduceNextCodedSymbol()) {
+			t.Fatalf("coded symbol %d differs between the default and Harmonic(2)", i)
+		}
+	}
+}
+
+func TestDegreeSequenceProbability(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 200000
+	maxIdx := uint64(100)
+	for name, seq := range testDegreeSequences {
+		hits := make([]int, maxIdx)
+		for j := 0; j < n; j++ {
+			m := randomMapping{rng.Uint64(), 0}
+			for m.lastIdx < maxIdx {
+				hits[m.lastIdx] += 1
+				last := m.lastIdx
+				if m.nextIndex(seq) <= last {
+					t.Fatalf("%s: mapping does not advance", name)
+				}
+			}
+		}
+		for i := uint64(0); i < maxIdx; i++ {
+			p := seq.Probability(i)
+			freq := float64(hits[i]) / float64(n)
+			// allow five standard deviations, plus the error of the
+			// approximation in Harmonic(2), which is also the tail of
+			// IBLTPrefix by default
+			tol := 5*math.Sqrt(p*(1-p)/float64(n)) + 0.005*p
+			if seq == Harmonic(2) || i >= 16 {
+				tol += 0.05*p
+			}
+			if math.Abs(freq-p) > tol {
+				t.Errorf("%s: coded symbol %d has frequency %.4f, expected %.4f", name, i, freq, p)
+			}
+		}
+	}
+}
+
+func TestDegreeSequenceEncodeAndDecode(t *testing.T) {
+	for name, seq := range testDegreeSequences {
+		enc := Encoder[*testSymbol]{}
+		enc.SetDegreeSequence(seq)
+		dec := Decoder[*testSymbol]{}
+		dec.SetDegreeSequence(seq)
+		penc := NewParallelEncoder[*testSymbol](3)
+		penc.SetDegreeSequence(seq)
+		sketch := make(Sketch[*testSymbol], 400).WithDegreeSequence(seq)
+		local := make(map[uint64]struct{})
+		remote := make(map[uint64]struct{})
+		for i := 0; i < 1000; i++ {
+			s := newTestSymbol(uint64(i))
+			switch i % 20 {
+			case 0:
+				enc.AddSymbol(s)
+				penc.AddSymbol(s)
+				sketch.AddSymbol(s)
+				remote[s.Hash()] = struct{}{}
+			case 1:
+				dec.AddSymbol(s)
+				sketch.RemoveSymbol(s)
+				local[s.Hash()] = struct{}{}
+			default:
+				enc.AddSymbol(s)
+				penc.AddSymbol(s)
+				dec.AddSymbol(s)
+			}
+		}
+		for i := 0; i < 400 && !(i > 0 && dec.Decoded()); i++ {
+			c := enc.ProduceNextCodedSymbol()
+			if !equalCodedSymbols(c, penc.ProduceNextCodedSymbol()) {
+				t.Fatalf("%s: coded symbol %d differs from parallel encoder", name, i)
+			}
+			dec.AddCodedSymbol(c)
+			if err := dec.TryDecode(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkDecoded(t, &dec, remote, local)
+		r, l, ok := sketch.Decode()
+		if !ok || len(r) != len(remote) || len(l) != len(local) {
+			t.Fatalf("%s: failed to decode sketch", name)
+		}
+	}
+}
@@ -3,6 +3,21 @@ package riblt
 // TODO: encoder should send conflicting transactions as-is when detected; since it happens very rarely and each pair of peers can use a secret hash key, an adversary cannot forge too many conflicts.
 // TODO: replace siphash with xxhash (or whatever that supports native 4-byte output)
 
+import (
+	"errors"
+	"unsafe"
+)
+
+var ErrNotFound = errors.New("symbol not found in the set")
+
+// Correction tells the decoder that Symbol was removed from the set of the
+// encoder after the encoder had produced Index coded symbols, which include
+// Symbol while the following ones do not.
+type Correction[T Symbol[T]] struct {
+	Symbol HashedSymbol[T]
+	Index int
+}
+
 type symbolMapping struct {
 	sourceIdx int
 	codedIdx int
@@ -11,8 +26,20 @@ type symbolMapping struct {
 // TODO: remove the heap?
 type mappingHeap []symbolMapping
 
-func (m mappingHeap) fixHead() {
-	curr := 0
+// The heap methods take pos, the index in the heap of the mapping of each
+// source symbol, and keep it up to date so that the mapping of any source
+// symbol can be found and removed.
+
+func (m mappingHeap) swap(i, j int, pos []int) {
+	m[i], m[j] = m[j], m[i]
+	pos[m[i].sourceIdx] = i
+	pos[m[j].sourceIdx] = j
+}
+
+// down moves the mapping at curr down the heap until its children are not
+// smaller, and reports whether it moved.
+func (m mappingHeap) down(curr int, pos []int) bool {
+	start := curr
 	for {
 		child := curr * 2 + 1
 		if child >= len(m) {
@@ -25,28 +52,42 @@ func (m mappingHeap) fixHead() {
 		if m[curr].codedIdx <= m[child].codedIdx {
 			break
 		}
-		m[curr], m[child] = m[child], m[curr]
+		m.swap(curr, child, pos)
 		curr = child
 	}
+	return curr != start
 }
 
-func (m mappingHeap) fixTail() {
-	curr := len(m)-1
+// up moves the mapping at curr up the heap until its parent is not larger.
+func (m mappingHeap) up(curr int, pos []int) {
 	for {
 		parent := (curr - 1) / 2
 		if curr == parent || m[parent].codedIdx <= m[curr].codedIdx {
 			break
 		}
-		m[parent], m[curr] = m[curr], m[parent]
+		m.swap(parent, curr, pos)
 		curr = parent
 	}
 }
 
+func (m mappingHeap) fixHead(pos []int) {
+	m.down(0, pos)
+}
+
+func (m mappingHeap) fixTail(pos []int) {
+	m.up(len(m)-1, pos)
+}
+
 type codingWindow[T Symbol[T]] struct {
 	symbols []HashedSymbol[T]
+	checksums []uint64	// hashes of symbols rehashed with key
 	mappings []randomMapping
 	queue mappingHeap
+	pos []int	// index in queue of the mapping of each symbol
 	nextIdx int
+	key Key
+	seq DegreeSequence
+	index map[uint64]int	// index of each symbol by hash; built on first lookup
 }
 
 func (e *codingWindow[T]) addSymbol(t T) {
@@ -55,14 +96,73 @@ func (e *codingWindow[T]) addSymbol(t T) {
 }
 
 func (e *codingWindow[T]) addHashedSymbol(t HashedSymbol[T]) {
-	e.addHashedSymbolWithMapping(t, randomMapping{t.Hash , 0})
+	kh := e.key.rehash(t.Hash)
+	e.addHashedSymbolWithMapping(t, kh, randomMapping{kh, 0})
 }
 
-func (e *codingWindow[T]) addHashedSymbolWithMapping(t HashedSymbol[T], m randomMapping) {
+func (e *codingWindow[T]) addHashedSymbolWithMapping(t HashedSymbol[T], checksum uint64, m randomMapping) {
 	e.symbols = append(e.symbols, t)
+	e.checksums = append(e.checksums, checksum)
 	e.mappings = append(e.mappings, m)
+	e.pos = append(e.pos, len(e.queue))
 	e.queue = append(e.queue, symbolMapping{len(e.symbols)-1, int(m.lastIdx)})
-	e.queue.fixTail()
+	e.queue.fixTail(e.pos)
+	if e.index != nil {
+		e.index[t.Hash] = len(e.symbols)-1
+	}
+}
+
+// lookup returns the index of the symbol with hash h in the window.
+func (e *codingWindow[T]) lookup(h uint64) (int, bool) {
+	if e.index == nil {
+		e.index = make(map[uint64]int, len(e.symbols))
+		for i, s := range e.symbols {
+			e.index[s.Hash] = i
+		}
+	}
+	sidx, there := e.index[h]
+	return sidx, there
+}
+
+// removeSymbol removes the symbol at index sidx from the window and returns
+// it along with its rehashed hash. The last symbol in the window takes its
+// index.
+func (e *codingWindow[T]) removeSymbol(sidx int) (HashedSymbol[T], uint64) {
+	removed := e.symbols[sidx]
+	checksum := e.checksums[sidx]
+	// remove its mapping from the heap
+	qidx := e.pos[sidx]
+	lastq := len(e.queue)-1
+	if qidx != lastq {
+		e.queue.swap(qidx, lastq, e.pos)
+		e.queue = e.queue[:lastq]
+		if !e.queue.down(qidx, e.pos) {
+			e.queue.up(qidx, e.pos)
+		}
+	} else {
+		e.queue = e.queue[:lastq]
+	}
+	// move the last symbol into its place
+	last := len(e.symbols)-1
+	if sidx != last {
+		e.symbols[sidx] = e.symbols[last]
+		e.checksums[sidx] = e.checksums[last]
+		e.mappings[sidx] = e.mappings[last]
+		e.pos[sidx] = e.pos[last]
+		e.queue[e.pos[sidx]].sourceIdx = sidx
+		if e.index != nil {
+			e.index[e.symbols[sidx].Hash] = sidx
+		}
+	}
+	e.symbols[last] = HashedSymbol[T]{}	// do not keep the symbol alive
+	e.symbols = e.symbols[:last]
+	e.checksums = e.checksums[:last]
+	e.mappings = e.mappings[:last]
+	e.pos = e.pos[:last]
+	if e.index != nil {
+		delete(e.index, removed.Hash)
+	}
+	return removed, checksum
 }
 
 func (e *codingWindow[T]) applyWindow(cw CodedSymbol[T], direction int64) CodedSymbol[T] {
@@ -71,31 +171,70 @@ func (e *codingWindow[T]) applyWindow(cw CodedSymbol[T], direction int64) CodedS
 		return cw
 	}
 	for e.queue[0].codedIdx == e.nextIdx {
-		cw = cw.apply(e.symbols[e.queue[0].sourceIdx], direction)
+		sidx := e.queue[0].sourceIdx
+		cw = cw.apply(HashedSymbol[T]{e.symbols[sidx].Symbol, e.checksums[sidx]}, direction)
 		// generate the next mapping
-		nextMap := e.mappings[e.queue[0].sourceIdx].nextIndex()
+		nextMap := e.mappings[e.queue[0].sourceIdx].nextIndex(e.seq)
 		e.queue[0].codedIdx = int(nextMap)
-		e.queue.fixHead()
+		e.queue.fixHead(e.pos)
 	}
 	e.nextIdx += 1
 	return cw
 }
 
+// memoryBytes returns the bytes allocated by e, counting each entry of the
+// index as 32 bytes.
+func (e *codingWindow[T]) memoryBytes() int {
+	b := cap(e.symbols) * int(unsafe.Sizeof(HashedSymbol[T]{}))
+	b += cap(e.checksums) * 8
+	b += cap(e.mappings) * int(unsafe.Sizeof(randomMapping{}))
+	b += cap(e.queue) * int(unsafe.Sizeof(symbolMapping{}))
+	b += cap(e.pos) * int(unsafe.Sizeof(int(0)))
+	b += len(e.index) * 32
+	return b
+}
+
+// touches returns whether applyWindow applies any symbol to the next coded
+// symbol.
+func (e *codingWindow[T]) touches() bool {
+	return len(e.queue) != 0 && e.queue[0].codedIdx == e.nextIdx
+}
+
 func (e *codingWindow[T]) reset() {
 	if len(e.symbols) != 0 {
 		e.symbols = e.symbols[:0]
 	}
+	if len(e.checksums) != 0 {
+		e.checksums = e.checksums[:0]
+	}
 	if len(e.mappings) != 0 {
 		e.mappings = e.mappings[:0]
 	}
 	if len(e.queue) != 0 {
 		e.queue = e.queue[:0]
 	}
+	if len(e.pos) != 0 {
+		e.pos = e.pos[:0]
+	}
+	e.index = nil
 	e.nextIdx = 0
 }
 
 type Encoder[T Symbol[T]] codingWindow[T]
 
+// SetKey sets the key to rehash symbol hashes with. It must be called before
+// any symbol is added, and the key is kept across Reset.
+func (e *Encoder[T]) SetKey(k Key) {
+	e.key = k
+}
+
+// SetDegreeSequence sets the degree sequence to map symbols to coded
+// symbols with. It must match that of the decoder, must be called before
+// any symbol is added, and is kept across Reset.
+func (e *Encoder[T]) SetDegreeSequence(seq DegreeSequence) {
+	e.seq = seq
+}
+
 func (e *Encoder[T]) AddSymbol(s T) {
 	(*codingWindow[T])(e).addSymbol(s)
 }
@@ -104,10 +243,53 @@ func (e *Encoder[T]) AddHashedSymbol(s HashedSymbol[T]) {
 	(*codingWindow[T])(e).addHashedSymbol(s)
 }
 
+// RemoveSymbol removes s from the set of the encoder. Coded symbols produced
+// afterwards do not include s. If s was included in coded symbols already
+// produced, the returned Correction must be delivered to the decoder before
+// it can decode.
+func (e *Encoder[T]) RemoveSymbol(s T) (Correction[T], error) {
+	return e.RemoveHashedSymbol(HashedSymbol[T]{s, s.Hash()})
+}
+
+func (e *Encoder[T]) RemoveHashedSymbol(s HashedSymbol[T]) (Correction[T], error) {
+	w := (*codingWindow[T])(e)
+	sidx, there := w.lookup(s.Hash)
+	if !there {
+		return Correction[T]{}, ErrNotFound
+	}
+	removed, _ := w.removeSymbol(sidx)
+	return Correction[T]{removed, w.nextIdx}, nil
+}
+
 func (e *Encoder[T]) ProduceNextCodedSymbol() CodedSymbol[T] {
 	return (*codingWindow[T])(e).applyWindow(CodedSymbol[T]{}, add)
 }
 
+// ProduceCodedSymbolRange returns the coded symbols from index start to end
+// of the current set of e, without producing the ones before start. It does
+// not change the coded symbols ProduceNextCodedSymbol produces. Encoders of
+// the same set may each produce a range of the same stream, which a decoder
+// takes with AddCodedSymbolAt. It returns nil unless 0 <= start < end.
+func (e *Encoder[T]) ProduceCodedSymbolRange(start, end int) []CodedSymbol[T] {
+	if start < 0 || end <= start {
+		return nil
+	}
+	w := (*codingWindow[T])(e)
+	res := make([]CodedSymbol[T], end-start)
+	for i, s := range w.symbols {
+		ks := HashedSymbol[T]{s.Symbol, w.checksums[i]}
+		m := randomMapping{w.checksums[i], 0}
+		for int(m.lastIdx) < start {
+			m.nextIndex(w.seq)
+		}
+		for int(m.lastIdx) < end {
+			res[int(m.lastIdx)-start] = res[int(m.lastIdx)-start].apply(ks, add)
+			m.nextIndex(w.seq)
+		}
+	}
+	return res
+}
+
 func (e *Encoder[T]) Reset() {
 	(*codingWindow[T])(e).reset()
 }
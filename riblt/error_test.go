@@ -0,0 +1,91 @@
+package riblt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeBudgetExhausted(t *testing.T) {
+	dec := Decoder[*testSymbol]{}
+	dec.SetBudget(10)
+	s := newTestSymbol(1)
+	for i := 0; i < 10; i++ {
+		c := CodedSymbol[*testSymbol]{}
+		switch i {
+		case 0:
+			// looks pure but the checksum is wrong
+			c = c.apply(HashedSymbol[*testSymbol]{s, s.Hash() ^ 1}, add)
+		case 1:
+			c.count = 100
+		}
+		dec.AddCodedSymbol(c)
+		err := dec.TryDecode()
+		if i < 9 {
+			if err != nil {
+				t.Fatalf("error %v before the budget is exhausted", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBudgetExhausted) {
+			t.Fatalf("expected budget exhausted error, got %v", err)
+		}
+		var de DecodeError
+		if !errors.As(err, &de) {
+			t.Fatal("error is not a DecodeError")
+		}
+		if de.Received != 10 || de.Pending != 2 {
+			t.Errorf("reported %d received and %d pending coded symbols, expected 10 and 2", de.Received, de.Pending)
+		}
+		if len(de.Stuck) != 1 || de.Stuck[0] != 0 {
+			t.Errorf("reported stuck coded symbols %v, expected [0]", de.Stuck)
+		}
+		if len(de.Overflow) != 1 || de.Overflow[0] != 1 {
+			t.Errorf("reported overflowing coded symbols %v, expected [1]", de.Overflow)
+		}
+	}
+	dec.Reset()
+	dec.AddCodedSymbol(CodedSymbol[*testSymbol]{})
+	if err := dec.TryDecode(); err != nil {
+		t.Errorf("error %v after decoding", err)
+	}
+}
+
+func TestDecodeDuplicateRecovery(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	// find a symbol not mapped to coded symbol 1, and forge the latter to
+	// contain it
+	var hs HashedSymbol[*testSymbol]
+	for id := uint64(1); ; id++ {
+		s := newTestSymbol(id)
+		hs = HashedSymbol[*testSymbol]{s, s.Hash()}
+		m := randomMapping{hs.Hash, 0}
+		if m.nextIndex(nil) > 1 {
+			break
+		}
+	}
+	enc.AddHashedSymbol(hs)
+	forged := 1
+	for i := 0; i <= forged; i++ {
+		c := enc.ProduceNextCodedSymbol()
+		if i == forged {
+			c = c.apply(hs, add)
+		}
+		dec.AddCodedSymbol(c)
+	}
+	err := dec.TryDecode()
+	if !errors.Is(err, ErrDuplicateSymbol) {
+		t.Fatalf("expected duplicate symbol error, got %v", err)
+	}
+	var de DecodeError
+	if !errors.As(err, &de) || len(de.Duplicates) != 1 || de.Duplicates[0] != hs.Hash {
+		t.Fatal("failed to report the duplicate symbol")
+	}
+	if err := dec.TryDecode(); !errors.Is(err, ErrDuplicateSymbol) {
+		t.Error("error is not kept until reset")
+	}
+	dec.Reset()
+	if err := dec.TryDecode(); err != nil {
+		t.Errorf("error %v kept after reset", err)
+	}
+}
@@ -0,0 +1,260 @@
+package riblt
+
+import (
+	"math"
+)
+
+// Estimate is an estimate of the size of a set difference.
+type Estimate struct {
+	Size  float64 // maximum likelihood estimate
+	Lower float64 // lower end of the 95% confidence interval
+	Upper float64 // upper end of the 95% confidence interval; may be +Inf
+}
+
+// z-score of the two-sided 95% confidence interval
+const estimateZ = 1.96
+
+// EstimateDifference estimates the size of the set difference from cs, the
+// first len(cs) coded symbols of the difference, e.g., a subtracted sketch or
+// coded symbols of an Encoder with the local set peeled off.
+//
+// Each symbol of the difference is mapped to coded symbol i with probability
+// p_i, which is 1/(1+i/2) for the default degree sequence. Coded symbol 0 holds
+// every symbol, so its count is the number of symbols on one side less the
+// number on the other, and the count of coded symbol i deviates from p_i times
+// that with variance d*p_i*(1-p_i), where d is the size of the difference.
+// This gives an estimate from the counts of the first few coded symbols, even
+// when len(cs) is much smaller than d. Coded symbol i is also empty with
+// probability (1-p_i)^d, which gives the maximum likelihood estimate from the
+// empty coded symbols; it is the sharper one once len(cs) is comparable to d.
+// EstimateDifference returns the one with the smaller standard error, with
+// the 95% confidence interval from its standard error.
+func EstimateDifference[T Symbol[T]](cs []CodedSymbol[T]) Estimate {
+	return estimateDifference(cs, nil)
+}
+
+// EstimateDifference estimates the size of the set difference from s, which
+// is usually the difference of two sketches, under the degree sequence of s.
+func (s KeyedSketch[T]) EstimateDifference() Estimate {
+	return estimateDifference(s.Sketch, s.Degrees)
+}
+
+func estimateDifference[T Symbol[T]](cs []CodedSymbol[T], seq DegreeSequence) Estimate {
+	empty := make([]bool, len(cs))
+	counts := make([]int64, len(cs))
+	var minSize int64
+	for i, c := range cs {
+		empty[i] = c.isZero()
+		counts[i] = c.count
+		minSize = maxAbs(minSize, c.count)
+	}
+	return estimate(empty, counts, nil, float64(minSize), seq)
+}
+
+// EstimateDifference estimates the size of the set difference from the coded
+// symbols received so far. See EstimateDifference for the method.
+func (d *Decoder[T]) EstimateDifference() Estimate {
+	if d.base != 0 {
+		// coded symbol 0 has been peeled off to empty and discarded, so
+		// the difference has been decoded
+		n := float64(len(d.remote.symbols) + len(d.local.symbols))
+		return Estimate{n, n, n}
+	}
+	// received coded symbols have decoded symbols peeled off, and the rest
+	// of the difference is not a random set, so estimate from the coded
+	// symbols as received by adding decoded symbols back
+	counts := make([]int64, len(d.cs))
+	empty := make([]bool, len(d.cs))
+	var missing []bool
+	for i, c := range d.cs {
+		if c.missing {
+			if missing == nil {
+				missing = make([]bool, len(d.cs))
+			}
+			missing[i] = true
+			continue
+		}
+		counts[i] = c.count
+		empty[i] = c.isZero()
+	}
+	restore := func(w *codingWindow[T], direction int64) {
+		for _, kh := range w.checksums {
+			m := randomMapping{kh, 0}
+			for int(m.lastIdx) < len(d.cs) {
+				counts[m.lastIdx] += direction
+				empty[m.lastIdx] = false
+				m.nextIndex(d.seq)
+			}
+		}
+	}
+	restore(&d.remote, add)
+	restore(&d.local, remove)
+	minSize := int64(len(d.remote.symbols) + len(d.local.symbols))
+	for _, c := range counts {
+		minSize = maxAbs(minSize, c)
+	}
+	return estimate(empty, counts, missing, float64(minSize), d.seq)
+}
+
+func maxAbs(m int64, c int64) int64 {
+	if c < 0 {
+		c = -c
+	}
+	if c > m {
+		return c
+	}
+	return m
+}
+
+// estimate returns the estimate of the number of symbols given which coded
+// symbols are empty and their counts, subject to the size being at least
+// minSize, where symbols are mapped to coded symbols with seq. Coded symbols
+// marked in missing, which may be nil, have not been received and are
+// skipped.
+func estimate(empty []bool, counts []int64, missing []bool, minSize float64, seq DegreeSequence) Estimate {
+	known := func(i int) bool {
+		return missing == nil || !missing[i]
+	}
+	if len(empty) == 0 || !known(0) {
+		return Estimate{0, 0, math.Inf(1)}
+	}
+	// every symbol is mapped to coded symbol 0
+	if empty[0] {
+		return Estimate{0, 0, 0}
+	}
+	if minSize < 1 {
+		minSize = 1
+	}
+	if seq == nil {
+		seq = Harmonic(2)
+	}
+	p := make([]float64, len(empty))
+	for i := 1; i < len(empty); i++ {
+		p[i] = seq.Probability(uint64(i))
+	}
+
+	size, se := estimateFromEmpty(empty, known, p, minSize)
+	if csize, cse := estimateFromCounts(counts, known, p, minSize); cse < se {
+		size, se = csize, cse
+	}
+	if math.IsInf(se, 1) {
+		return Estimate{size, minSize, math.Inf(1)}
+	}
+	lower := size - estimateZ*se
+	if lower < minSize {
+		lower = minSize
+	}
+	return Estimate{size, lower, size + estimateZ*se}
+}
+
+// estimateFromEmpty returns the maximum likelihood estimate of the number of
+// symbols given which coded symbols are empty, and its standard error from
+// the Fisher information at the estimate. p_i is the probability that a
+// symbol is mapped to coded symbol i.
+func estimateFromEmpty(empty []bool, known func(int) bool, p []float64, minSize float64) (float64, float64) {
+	nempty := 0
+	for i, e := range empty {
+		if e && known(i) {
+			nempty += 1
+		}
+	}
+	if nempty == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+
+	// log q_i, where q_i = 1-p_i is the probability that a symbol is not
+	// mapped to coded symbol i
+	logq := make([]float64, len(empty))
+	for i := 1; i < len(empty); i++ {
+		logq[i] = math.Log1p(-p[i])
+	}
+	// derivative of the log likelihood, which is concave in d
+	dl := func(d float64) float64 {
+		res := 0.0
+		for i := 1; i < len(empty); i++ {
+			if !known(i) {
+				continue
+			}
+			if empty[i] {
+				res += logq[i]
+			} else {
+				qd := math.Exp(d * logq[i])
+				res -= qd * logq[i] / (1 - qd)
+			}
+		}
+		return res
+	}
+	size := minSize
+	if dl(minSize) > 0 {
+		lo, hi := minSize, 2*minSize
+		for dl(hi) > 0 {
+			lo = hi
+			hi *= 2
+		}
+		for i := 0; i < 64 && hi-lo > 1e-6*hi; i++ {
+			mid := (lo + hi) / 2
+			if dl(mid) > 0 {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		size = (lo + hi) / 2
+	}
+
+	fisher := 0.0
+	for i := 1; i < len(empty); i++ {
+		if !known(i) {
+			continue
+		}
+		qd := math.Exp(size * logq[i])
+		if qd < 1 {
+			fisher += qd * logq[i] * logq[i] / (1 - qd)
+		}
+	}
+	if fisher == 0 {
+		return size, math.Inf(1)
+	}
+	return size, 1 / math.Sqrt(fisher)
+}
+
+// estimateFromCounts returns the estimate of the number of symbols d from the
+// counts of the coded symbols, and its standard error. The count of coded
+// symbol i less p_i times the count of coded symbol 0 has mean zero and
+// variance d*v_i, where v_i = p_i*(1-p_i), so each squared deviation r_i^2 is
+// an unbiased estimate of d*v_i, with variance about d*v_i*(2*d*v_i+1). The
+// estimate weighs them by the inverse of that, which depends on d, so it is
+// found by iteration.
+func estimateFromCounts(counts []int64, known func(int) bool, p []float64, minSize float64) (float64, float64) {
+	c0 := float64(counts[0])
+	// sums of w_i*r_i^2 and w_i*v_i under the weights for d
+	sums := func(d float64) (float64, float64) {
+		var wr, wv float64
+		for i := 1; i < len(counts); i++ {
+			if !known(i) {
+				continue
+			}
+			v := p[i] * (1 - p[i])
+			r := float64(counts[i]) - c0*p[i]
+			w := 1 / (2*d*v + 1)
+			wr += w * r * r
+			wv += w * v
+		}
+		return wr, wv
+	}
+	size := minSize
+	for i := 0; i < 100; i++ {
+		wr, wv := sums(size)
+		if wv == 0 {
+			return minSize, math.Inf(1)
+		}
+		next := math.Max(wr/wv, minSize)
+		converged := math.Abs(next-size) <= 1e-6*size
+		size = next
+		if converged {
+			break
+		}
+	}
+	_, wv := sums(size)
+	return size, math.Sqrt(size / wv)
+}
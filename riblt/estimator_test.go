@@ -0,0 +1,61 @@
+package riblt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateDifference(t *testing.T) {
+	ntrials := 50
+	for _, test := range []struct{ ndiff, k int }{
+		{0, 10}, {10, 30}, {100, 210}, {1000, 2010},
+		// far fewer coded symbols than differences
+		{1000, 50}, {10000, 100},
+	} {
+		ndiff := test.ndiff
+		covered := 0
+		sum := 0.0
+		for trial := 0; trial < ntrials; trial++ {
+			enc := Encoder[*testSymbol]{}
+			dec := Decoder[*testSymbol]{}
+			base := uint64(trial) << 32
+			for i := 0; i < ndiff; i++ {
+				s := newTestSymbol(base + uint64(i))
+				if i%2 == 0 {
+					enc.AddSymbol(s)
+				} else {
+					dec.AddSymbol(s)
+				}
+			}
+			for i := ndiff; i < ndiff+100; i++ {
+				s := newTestSymbol(base + uint64(i))
+				enc.AddSymbol(s)
+				dec.AddSymbol(s)
+			}
+			for i := 0; i < test.k; i++ {
+				dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+				// decoding before estimating must not bias the
+				// estimate
+				if trial%2 == 1 {
+					dec.TryDecode()
+				}
+			}
+			e := dec.EstimateDifference()
+			if e.Lower > e.Size || e.Size > e.Upper || math.IsInf(e.Upper, 1) {
+				t.Fatalf("estimate %v not within its bounds", e)
+			}
+			if e.Lower <= float64(ndiff) && float64(ndiff) <= e.Upper {
+				covered += 1
+			}
+			sum += e.Size
+		}
+		mean := sum / float64(ntrials)
+		t.Logf("difference %d, %d coded symbols: mean estimate %.2f, %d/%d within bounds", ndiff, test.k, mean, covered, ntrials)
+		if covered < ntrials*8/10 {
+			t.Errorf("difference %d: only %d/%d estimates have the difference within bounds", ndiff, covered, ntrials)
+		}
+		if ndiff > 0 && (mean < float64(ndiff)*0.8 || mean > float64(ndiff)*1.2) {
+			t.Errorf("difference %d: mean estimate %.2f", ndiff, mean)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package riblt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+var sketchFileMagic = [4]byte{'R', 'B', 'S', 'K'}
+
+var ErrChecksum = errors.New("sketch file checksum mismatch")
+
+// WriteFile atomically replaces the file name with a snapshot of s. The file
+// holds magic bytes, the sketch as marshaled by Sketch.Marshal, and a CRC-32
+// of the preceding bytes. The key of a KeyedSketch is not saved, so the
+// sketch must be wrapped with the same key again after loading.
+func (s Sketch[T]) WriteFile(name string, codec SymbolCodec[T]) error {
+	data, err := s.Marshal(codec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(sketchFileMagic)+len(data)+4)
+	buf = append(buf, sketchFileMagic[:]...)
+	buf = append(buf, data...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	// write to a temporary file in the same directory and rename it over
+	// name, so that a crash leaves either the old or the new snapshot
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ReadSketchFile loads a sketch saved by Sketch.WriteFile.
+func ReadSketchFile[T Symbol[T]](name string, codec SymbolCodec[T]) (Sketch[T], error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < len(sketchFileMagic)+4 {
+		return nil, ErrMalformed
+	}
+	if !bytes.Equal(buf[:len(sketchFileMagic)], sketchFileMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	body := buf[:len(buf)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, ErrChecksum
+	}
+	var s Sketch[T]
+	if err := s.Unmarshal(codec, body[len(sketchFileMagic):]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
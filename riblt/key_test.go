@@ -0,0 +1,133 @@
+package riblt
+
+import (
+	"testing"
+)
+
+var testKeyA = NewKey([SaltSize]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f})
+var testKeyB = NewKey([SaltSize]byte{0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f})
+
+// adversarialSymbols returns n symbols that, under key k, map to coded
+// symbol 0 and then to nothing before coded symbol gap. A decoder receiving
+// them as the set difference cannot make progress until it receives coded
+// symbol gap.
+func adversarialSymbols(k Key, n int, gap uint64) []*testSymbol {
+	res := []*testSymbol{}
+	var id uint64 = 1 << 32
+	for len(res) < n {
+		s := newTestSymbol(id)
+		id += 1
+		m := randomMapping{k.rehash(s.Hash()), 0}
+		if m.nextIndex(nil) >= gap {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func codedSymbolsToDecode(k Key, diff []*testSymbol, ncommon int) int {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	enc.SetKey(k)
+	dec.SetKey(k)
+	for _, s := range diff {
+		enc.AddSymbol(s)
+	}
+	for i := 0; i < ncommon; i++ {
+		s := newTestSymbol(uint64(i))
+		enc.AddSymbol(s)
+		dec.AddSymbol(s)
+	}
+	ncw := 0
+	for !dec.Decoded() || ncw == 0 {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		ncw += 1
+		dec.TryDecode()
+	}
+	return ncw
+}
+
+func TestKeyedEncodeAndDecode(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	enc.SetKey(testKeyA)
+	dec.SetKey(testKeyA)
+	for i := 0; i < 100; i++ {
+		dec.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for i := 100; i < 300; i++ {
+		s := newTestSymbol(uint64(i))
+		enc.AddSymbol(s)
+		if i >= 200 {
+			dec.AddSymbol(s)
+		}
+	}
+	for !dec.Decoded() || len(dec.cs) == 0 {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	for _, s := range dec.Remote() {
+		if s.Hash != s.Symbol.Hash() {
+			t.Fatal("recovered remote symbol carries rehashed hash")
+		}
+	}
+	if len(dec.Remote()) != 100 || len(dec.Local()) != 100 {
+		t.Errorf("decoded %d remote and %d local symbols, expected 100 each", len(dec.Remote()), len(dec.Local()))
+	}
+}
+
+func TestKeyedEncodingDiffers(t *testing.T) {
+	encA := Encoder[*testSymbol]{}
+	encA.SetKey(testKeyA)
+	encB := Encoder[*testSymbol]{}
+	encB.SetKey(testKeyB)
+	for i := 0; i < 100; i++ {
+		encA.AddSymbol(newTestSymbol(uint64(i)))
+		encB.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	a := encA.ProduceNextCodedSymbol()
+	b := encB.ProduceNextCodedSymbol()
+	if a.checksum == b.checksum {
+		t.Error("checksums under different keys are equal")
+	}
+}
+
+func TestAdversarialSymbols(t *testing.T) {
+	var gap uint64 = 128
+	diff := adversarialSymbols(testKeyA, 30, gap)
+
+	underA := codedSymbolsToDecode(testKeyA, diff, 1000)
+	if underA < int(gap) {
+		t.Errorf("adversarial symbols decoded after %d coded symbols under the key they are built for, expected at least %d", underA, gap)
+	}
+	underB := codedSymbolsToDecode(testKeyB, diff, 1000)
+	if underB >= int(gap) {
+		t.Errorf("adversarial symbols decoded after %d coded symbols under another key", underB)
+	}
+	t.Logf("%d coded symbols under the attacked key, %d under another key", underA, underB)
+}
+
+func TestKeyedSketch(t *testing.T) {
+	s1 := make(Sketch[*testSymbol], 100).WithKey(testKeyA)
+	s2 := make(Sketch[*testSymbol], 100).WithKey(testKeyA)
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(uint64(i))
+		if i >= 20 {
+			s1.AddSymbol(s)
+		}
+		if i < 980 {
+			s2.AddSymbol(s)
+		}
+	}
+	s1.Subtract(s2.Sketch)
+	remote, local, ok := s1.Decode()
+	if !ok {
+		t.Fatal("failed to decode keyed sketch")
+	}
+	if len(remote) != 20 || len(local) != 20 {
+		t.Errorf("decoded %d remote and %d local symbols, expected 20 each", len(remote), len(local))
+	}
+	if _, _, ok := s1.Sketch.Decode(); ok {
+		t.Error("decoded keyed sketch without the key")
+	}
+}
@@ -0,0 +1,113 @@
+// Package kvmap reconciles maps with riblt. An entry of a map is a riblt
+// symbol whose hash covers both the key and the value, so that an entry
+// whose value differs between two maps shows up as one local and one remote
+// symbol, which the Decoder links by the key.
+package kvmap
+
+import (
+	"encoding/binary"
+	"github.com/dchest/siphash"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+)
+
+// Entry is a key and a value of a map. Its hash covers both.
+type Entry[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	Key   K
+	Value V
+}
+
+func (e Entry[K, V]) XOR(t2 Entry[K, V]) Entry[K, V] {
+	e.Key = e.Key.XOR(t2.Key)
+	e.Value = e.Value.XOR(t2.Value)
+	return e
+}
+
+func (e Entry[K, V]) Hash() uint64 {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[0:8], e.Key.Hash())
+	binary.LittleEndian.PutUint64(b[8:16], e.Value.Hash())
+	return siphash.Hash(0, 0, b[:])
+}
+
+// Encoder produces coded symbols of a map.
+type Encoder[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	riblt.Encoder[Entry[K, V]]
+}
+
+func (e *Encoder[K, V]) AddEntry(k K, v V) {
+	e.AddSymbol(Entry[K, V]{k, v})
+}
+
+// Decoder decodes the difference between a remote map, whose coded symbols
+// it receives, and the local map.
+type Decoder[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	riblt.Decoder[Entry[K, V]]
+}
+
+func (d *Decoder[K, V]) AddEntry(k K, v V) {
+	d.AddSymbol(Entry[K, V]{k, v})
+}
+
+// Update is a key whose value differs between the local and the remote map.
+type Update[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	Key    K
+	Local  V
+	Remote V
+}
+
+// Diff is the difference between the local and the remote map.
+type Diff[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	LocalOnly  []Entry[K, V]	// keys only the local map has
+	RemoteOnly []Entry[K, V]	// keys only the remote map has
+	Updated    []Update[K, V]	// keys whose values differ
+}
+
+// Diff returns the difference between the maps. It is complete once the
+// decoder has decoded.
+func (d *Decoder[K, V]) Diff() Diff[K, V] {
+	res := Diff[K, V]{}
+	remote := make(map[uint64]Entry[K, V], len(d.Remote()))
+	for _, s := range d.Remote() {
+		remote[s.Symbol.Key.Hash()] = s.Symbol
+	}
+	for _, s := range d.Local() {
+		kh := s.Symbol.Key.Hash()
+		if r, there := remote[kh]; there {
+			res.Updated = append(res.Updated, Update[K, V]{s.Symbol.Key, s.Symbol.Value, r.Value})
+			delete(remote, kh)
+		} else {
+			res.LocalOnly = append(res.LocalOnly, s.Symbol)
+		}
+	}
+	// keep the order in which remote entries are decoded
+	for _, s := range d.Remote() {
+		if _, there := remote[s.Symbol.Key.Hash()]; there {
+			res.RemoteOnly = append(res.RemoteOnly, s.Symbol)
+		}
+	}
+	return res
+}
+
+// Resolver returns the value to keep for a key whose values differ.
+type Resolver[K riblt.Symbol[K], V riblt.Symbol[V]] func(key K, local, remote V) V
+
+// PreferRemote is a Resolver that keeps the remote value.
+func PreferRemote[K riblt.Symbol[K], V riblt.Symbol[V]](key K, local, remote V) V {
+	return remote
+}
+
+// Merge returns the entries to set in the local map so that it has every
+// key of the remote map: the entries only the remote map has, and for the
+// keys whose values differ, the values returned by resolve that differ from
+// the local ones.
+func (d Diff[K, V]) Merge(resolve Resolver[K, V]) []Entry[K, V] {
+	res := make([]Entry[K, V], 0, len(d.RemoteOnly)+len(d.Updated))
+	res = append(res, d.RemoteOnly...)
+	for _, u := range d.Updated {
+		v := resolve(u.Key, u.Local, u.Remote)
+		if v.Hash() != u.Local.Hash() {
+			res = append(res, Entry[K, V]{u.Key, v})
+		}
+	}
+	return res
+}
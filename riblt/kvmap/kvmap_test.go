@@ -0,0 +1,184 @@
+package kvmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"github.com/yangl1996/rateless-set-reconcile/riblt/symbols"
+)
+
+type testKey = symbols.Array[[8]byte]
+
+func newKey(i uint64) testKey {
+	k := testKey{}
+	binary.LittleEndian.PutUint64(k.Data[:], i)
+	return k
+}
+
+func randomValue(rng *rand.Rand) symbols.Blob {
+	b := make([]byte, rng.Intn(64))
+	rng.Read(b)
+	return symbols.NewBlob(b)
+}
+
+// mutate deletes, inserts and updates n random keys each in m, and returns
+// the keys it touched.
+func mutate(rng *rand.Rand, m map[testKey]symbols.Blob, n int, nextKey *uint64) (deleted, inserted, updated []testKey) {
+	for k := range m {
+		if len(deleted) < n {
+			deleted = append(deleted, k)
+		} else if len(updated) < n {
+			updated = append(updated, k)
+		} else {
+			break
+		}
+	}
+	for _, k := range deleted {
+		delete(m, k)
+	}
+	for _, k := range updated {
+		m[k] = randomValue(rng)
+	}
+	for i := 0; i < n; i++ {
+		k := newKey(*nextKey)
+		*nextKey += 1
+		m[k] = randomValue(rng)
+		inserted = append(inserted, k)
+	}
+	return
+}
+
+func TestReconcileMutatedMaps(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	size := 50000
+	local := make(map[testKey]symbols.Blob)
+	remote := make(map[testKey]symbols.Blob)
+	var nextKey uint64
+	for ; nextKey < uint64(size); nextKey++ {
+		v := randomValue(rng)
+		local[newKey(nextKey)] = v
+		remote[newKey(nextKey)] = v
+	}
+	// the local side has the keys the remote side deleted, and the remote
+	// side has the keys it inserted, and vice versa
+	expected := make(map[testKey]string)
+	rdel, rins, rupd := mutate(rng, remote, 40, &nextKey)
+	ldel, lins, lupd := mutate(rng, local, 30, &nextKey)
+	for _, k := range rdel {
+		if _, there := local[k]; there {
+			expected[k] = "local"
+		}
+	}
+	for _, k := range ldel {
+		if _, there := remote[k]; there {
+			expected[k] = "remote"
+		} else {
+			delete(expected, k)
+		}
+	}
+	for _, k := range rins {
+		expected[k] = "remote"
+	}
+	for _, k := range lins {
+		expected[k] = "local"
+	}
+	for _, k := range append(rupd, lupd...) {
+		_, inLocal := local[k]
+		_, inRemote := remote[k]
+		if inLocal && inRemote {
+			expected[k] = "updated"
+		}
+	}
+
+	enc := Encoder[testKey, symbols.Blob]{}
+	dec := Decoder[testKey, symbols.Blob]{}
+	for k, v := range remote {
+		enc.AddEntry(k, v)
+	}
+	for k, v := range local {
+		dec.AddEntry(k, v)
+	}
+	for i := 0; ; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		if err := dec.TryDecode(); err != nil {
+			t.Fatal(err)
+		}
+		if dec.Decoded() {
+			break
+		}
+		if i > 10*len(expected) {
+			t.Fatal("failed to decode")
+		}
+	}
+
+	diff := dec.Diff()
+	check := func(k testKey, kind string) {
+		t.Helper()
+		if expected[k] != kind {
+			t.Fatalf("key reported as %s, expected %s", kind, expected[k])
+		}
+		delete(expected, k)
+	}
+	for _, e := range diff.LocalOnly {
+		check(e.Key, "local")
+		if !bytes.Equal(e.Value.Bytes(), local[e.Key].Bytes()) {
+			t.Fatal("incorrect value of local entry")
+		}
+	}
+	for _, e := range diff.RemoteOnly {
+		check(e.Key, "remote")
+		if !bytes.Equal(e.Value.Bytes(), remote[e.Key].Bytes()) {
+			t.Fatal("incorrect value of remote entry")
+		}
+	}
+	for _, u := range diff.Updated {
+		check(u.Key, "updated")
+		if !bytes.Equal(u.Local.Bytes(), local[u.Key].Bytes()) || !bytes.Equal(u.Remote.Bytes(), remote[u.Key].Bytes()) {
+			t.Fatal("incorrect values of updated entry")
+		}
+	}
+	if len(expected) != 0 {
+		t.Fatalf("%d keys not reported", len(expected))
+	}
+
+	// keep the longer value, and merge the remote map into the local one
+	longer := func(key testKey, l, r symbols.Blob) symbols.Blob {
+		if len(r.Bytes()) > len(l.Bytes()) {
+			return r
+		}
+		return l
+	}
+	for _, e := range diff.Merge(longer) {
+		local[e.Key] = e.Value
+	}
+	for k, v := range remote {
+		lv, there := local[k]
+		if !there {
+			t.Fatal("remote key missing after merge")
+		}
+		if !bytes.Equal(lv.Bytes(), v.Bytes()) && len(lv.Bytes()) < len(v.Bytes()) {
+			t.Fatal("conflict not resolved with the resolver")
+		}
+	}
+}
+
+func TestMergePreferRemote(t *testing.T) {
+	d := Diff[testKey, symbols.Blob]{
+		RemoteOnly: []Entry[testKey, symbols.Blob]{{newKey(1), symbols.NewBlob([]byte("a"))}},
+		Updated: []Update[testKey, symbols.Blob]{
+			{newKey(2), symbols.NewBlob([]byte("b")), symbols.NewBlob([]byte("c"))},
+		},
+		LocalOnly: []Entry[testKey, symbols.Blob]{{newKey(3), symbols.NewBlob([]byte("d"))}},
+	}
+	m := d.Merge(PreferRemote[testKey, symbols.Blob])
+	if len(m) != 2 || m[0].Key != newKey(1) || m[1].Key != newKey(2) || string(m[1].Value.Bytes()) != "c" {
+		t.Fatal("incorrect merged entries")
+	}
+	keepLocal := func(key testKey, l, r symbols.Blob) symbols.Blob {
+		return l
+	}
+	if m := d.Merge(keepLocal); len(m) != 1 {
+		t.Fatal("merge sets a key resolved to the local value")
+	}
+}
@@ -0,0 +1,111 @@
+package riblt
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// runLossy reconciles a difference of d symbols over a link that drops each
+// coded symbol with probability loss and delays it by up to 32 coded
+// symbols. Coded symbol 0 is sent again every 16 coded symbols, as Decoded
+// requires it. It returns the number of coded symbols sent and received,
+// not counting the copies of coded symbol 0. If sealed is set, the local set
+// of the decoder is sealed, so that it discards the coded symbols it is done
+// with.
+func runLossy(t *testing.T, rng *rand.Rand, d int, loss float64, sealed bool) (int, int) {
+	t.Helper()
+	enc := &Encoder[*testSymbol]{}
+	dec := &Decoder[*testSymbol]{}
+	base := rng.Uint64()
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(base + uint64(i))
+		enc.AddSymbol(s)
+		dec.AddSymbol(s)
+	}
+	for i := 0; i < d; i++ {
+		enc.AddSymbol(newTestSymbol(base + uint64(1000+i)))
+	}
+	if sealed {
+		dec.SealLocal()
+	}
+	type packet struct {
+		idx  int
+		at   int
+		c    CodedSymbol[*testSymbol]
+		copy bool
+	}
+	first := enc.ProduceCodedSymbolRange(0, 1)[0]
+	inflight := []packet{}
+	sent, received := 0, 0
+	for now := 0; ; now++ {
+		if now > 100*d {
+			t.Fatal("failed to decode")
+		}
+		c := enc.ProduceNextCodedSymbol()
+		if rng.Float64() >= loss {
+			inflight = append(inflight, packet{sent, now + rng.Intn(32), c, false})
+		}
+		sent += 1
+		if now%16 == 15 {
+			inflight = append(inflight, packet{0, now + rng.Intn(32), first, true})
+		}
+		sort.SliceStable(inflight, func(i, j int) bool {
+			return inflight[i].at < inflight[j].at
+		})
+		for len(inflight) != 0 && inflight[0].at <= now {
+			p := inflight[0]
+			inflight = inflight[1:]
+			if !p.copy {
+				received += 1
+			}
+			dec.AddCodedSymbolAt(p.idx, p.c)
+		}
+		if err := dec.TryDecode(); err != nil {
+			t.Fatal(err)
+		}
+		if dec.received() != 0 && dec.Decoded() {
+			break
+		}
+	}
+	if len(dec.Remote()) != d || len(dec.Local()) != 0 {
+		t.Fatalf("decoded %d remote and %d local symbols, expected %d and 0", len(dec.Remote()), len(dec.Local()), d)
+	}
+	return sent, received
+}
+
+// TestLossyDecode measures how many more coded symbols are needed when some
+// are lost or reordered. Under moderate loss, the received coded symbols
+// are about as useful as without loss. Heavy loss thins out the coded
+// symbols each symbol is mapped to, and takes several times as many.
+func TestLossyDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const d = 200
+	const trials = 20
+	lossless := 0.0
+	for _, loss := range []float64{0, 0.1, 0.3, 0.5} {
+		sent, received := 0, 0
+		for i := 0; i < trials; i++ {
+			s, r := runLossy(t, rng, d, loss, false)
+			sent += s
+			received += r
+		}
+		so := float64(sent) / trials / d
+		ro := float64(received) / trials / d
+		t.Logf("loss %.1f: %.2f sent, %.2f received per symbol of difference", loss, so, ro)
+		if loss == 0 {
+			lossless = ro
+		} else if loss <= 0.3 && ro > lossless*1.25 {
+			t.Errorf("loss %.1f: %.2f coded symbols received per symbol of difference, %.2f without loss", loss, ro, lossless)
+		}
+	}
+}
+
+// TestLossySealedDecode checks that a sealed decoder, which discards coded
+// symbol 0 once it is peeled off, still reports decoding under loss.
+func TestLossySealedDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		runLossy(t, rng, 200, 0.3, true)
+	}
+}
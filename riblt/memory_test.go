@@ -0,0 +1,89 @@
+package riblt
+
+import (
+	"runtime"
+	"testing"
+)
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var st runtime.MemStats
+	runtime.ReadMemStats(&st)
+	return st.HeapAlloc
+}
+
+func TestSealedDecoderMemory(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(uint64(i))
+		if i%10 != 0 {
+			dec.AddSymbol(s)
+		}
+		enc.AddSymbol(s)
+	}
+	dec.SealLocal()
+	var before uint64
+	var stats MemoryStats
+	for i := 0; i < 200000; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		if err := dec.TryDecode(); err != nil {
+			t.Fatal(err)
+		}
+		if i == 20000 {
+			if !dec.Decoded() {
+				t.Fatal("failed to decode")
+			}
+			before = heapAlloc()
+			stats = dec.MemoryStats()
+		}
+	}
+	after := heapAlloc()
+	if after > before + 1<<20 {
+		t.Errorf("heap grew from %d to %d bytes", before, after)
+	}
+	st := dec.MemoryStats()
+	if st.Discarded != 200000 || st.CodedSymbols != 0 {
+		t.Errorf("kept %d and discarded %d coded symbols, expected to discard all", st.CodedSymbols, st.Discarded)
+	}
+	if st.Symbols != 1000 {
+		t.Errorf("reported %d symbols, expected 1000", st.Symbols)
+	}
+	if st.Bytes > stats.Bytes {
+		t.Errorf("live bytes grew from %d to %d", stats.Bytes, st.Bytes)
+	}
+	checkDecoded(t, &dec, func() map[uint64]struct{} {
+		m := make(map[uint64]struct{})
+		for i := 0; i < 1000; i += 10 {
+			m[newTestSymbol(uint64(i)).Hash()] = struct{}{}
+		}
+		return m
+	}(), map[uint64]struct{}{})
+}
+
+func TestUnsealedDecoderKeepsCodedSymbols(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	for i := 0; i < 100; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	if st := dec.MemoryStats(); st.CodedSymbols != 1000 || st.Discarded != 0 {
+		t.Errorf("kept %d and discarded %d coded symbols, expected to keep all", st.CodedSymbols, st.Discarded)
+	}
+	// a symbol decoded as remote can still be added to the local set
+	dec.AddSymbol(newTestSymbol(0))
+	dec.SealLocal()
+	if st := dec.MemoryStats(); st.CodedSymbols != 0 || st.Discarded != 1000 {
+		t.Errorf("kept %d and discarded %d coded symbols after sealing, expected to discard all", st.CodedSymbols, st.Discarded)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("adding a symbol to a sealed decoder did not panic")
+		}
+	}()
+	dec.AddSymbol(newTestSymbol(1000))
+}
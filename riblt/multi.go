@@ -0,0 +1,147 @@
+package riblt
+
+// MultiDecoder decodes coded symbol streams from several encoders, e.g., one
+// per peer, against a local set. A symbol recovered from one stream is
+// added right away to the local set of every other stream that accepts it,
+// so that it is peeled off their coded symbols as well. When the sets of the
+// encoders overlap, this cuts the total number of coded symbols needed.
+//
+// A recovered symbol is reported by Remote of the stream it was recovered
+// from only. If the encoder of another stream does not have it, that stream
+// decodes it as a Local symbol. All streams must use the same key and degree
+// sequence.
+type MultiDecoder[T Symbol[T]] struct {
+	streams []multiStream[T]
+	key Key
+	seq DegreeSequence
+}
+
+type multiStream[T Symbol[T]] struct {
+	*Decoder[T]
+	accept func(HashedSymbol[T]) bool
+}
+
+// SetKey sets the key to rehash symbol hashes with. It must be called before
+// any stream is added.
+func (d *MultiDecoder[T]) SetKey(k Key) {
+	d.key = k
+}
+
+// SetDegreeSequence sets the degree sequence to map symbols to coded
+// symbols with. It must be called before any stream is added.
+func (d *MultiDecoder[T]) SetDegreeSequence(seq DegreeSequence) {
+	d.seq = seq
+}
+
+// AddStream adds a stream of coded symbols and returns its index. accept
+// reports whether the encoder of the stream covers a symbol, e.g., when the
+// encoder only encodes one shard of its set; only symbols it accepts are
+// added to the local set of the stream. A nil accept covers every symbol.
+func (d *MultiDecoder[T]) AddStream(accept func(HashedSymbol[T]) bool) int {
+	dec := &Decoder[T]{}
+	dec.SetKey(d.key)
+	dec.SetDegreeSequence(d.seq)
+	d.streams = append(d.streams, multiStream[T]{dec, accept})
+	return len(d.streams)-1
+}
+
+func (s multiStream[T]) accepts(t HashedSymbol[T]) bool {
+	return s.accept == nil || s.accept(t)
+}
+
+func (d *MultiDecoder[T]) AddSymbol(s T) {
+	d.AddHashedSymbol(HashedSymbol[T]{s, s.Hash()})
+}
+
+// AddHashedSymbol adds s to the local set of every stream that accepts it.
+func (d *MultiDecoder[T]) AddHashedSymbol(s HashedSymbol[T]) {
+	for i := range d.streams {
+		if d.streams[i].accepts(s) {
+			d.streams[i].AddHashedSymbol(s)
+		}
+	}
+}
+
+// AddHashedSymbolTo adds s to the local set of stream i only.
+func (d *MultiDecoder[T]) AddHashedSymbolTo(i int, s HashedSymbol[T]) {
+	d.streams[i].AddHashedSymbol(s)
+}
+
+func (d *MultiDecoder[T]) AddCodedSymbol(i int, c CodedSymbol[T]) {
+	d.streams[i].AddCodedSymbol(c)
+}
+
+// TryDecode decodes every stream, sharing recovered symbols among them,
+// until no stream makes progress. It keeps decoding the other streams when
+// one fails, and returns the first error.
+func (d *MultiDecoder[T]) TryDecode() error {
+	var first error
+	for progress := true; progress; {
+		progress = false
+		for i := range d.streams {
+			s := d.streams[i]
+			n := len(s.remote.symbols)
+			if err := s.Decoder.TryDecode(); err != nil && first == nil {
+				first = err
+			}
+			for _, t := range s.remote.symbols[n:] {
+				if d.share(i, t) {
+					progress = true
+				}
+			}
+		}
+	}
+	return first
+}
+
+// share adds t, which is recovered from stream from, to the local sets of
+// the other streams that accept it and do not have it yet.
+func (d *MultiDecoder[T]) share(from int, t HashedSymbol[T]) bool {
+	shared := false
+	for j := range d.streams {
+		s := d.streams[j]
+		if j == from || !s.accepts(t) {
+			continue
+		}
+		if _, there := s.window.lookup(t.Hash); there {
+			continue
+		}
+		s.AddHashedSymbol(t)
+		shared = true
+	}
+	return shared
+}
+
+func (d *MultiDecoder[T]) NumStreams() int {
+	return len(d.streams)
+}
+
+// Decoded returns whether stream i has decoded.
+func (d *MultiDecoder[T]) Decoded(i int) bool {
+	return d.streams[i].Decoded()
+}
+
+// Remote returns the symbols recovered from stream i that the local set
+// does not have.
+func (d *MultiDecoder[T]) Remote(i int) []HashedSymbol[T] {
+	return d.streams[i].Remote()
+}
+
+// Local returns the symbols in the local set of stream i, including those
+// shared from other streams, that the encoder of stream i does not have.
+func (d *MultiDecoder[T]) Local(i int) []HashedSymbol[T] {
+	return d.streams[i].Local()
+}
+
+// ResetStream resets stream i, e.g., when its encoder starts over, and
+// clears its local set. Symbols recovered from other streams are shared
+// with it again only as they are recovered afterwards.
+func (d *MultiDecoder[T]) ResetStream(i int) {
+	d.streams[i].Reset()
+}
+
+func (d *MultiDecoder[T]) Reset() {
+	for i := range d.streams {
+		d.streams[i].Reset()
+	}
+}
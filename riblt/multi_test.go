@@ -0,0 +1,141 @@
+package riblt
+
+import (
+	"testing"
+)
+
+func TestMultiDecoderSharesRecoveredSymbols(t *testing.T) {
+	nstreams := 3
+	ncommon := 1000
+	nshared := 100	// symbols all encoders have but the local set does not
+	nunique := 20	// symbols only one encoder has
+	nlocal := 10	// symbols only the local set has
+	var nextId uint64
+	newSymbol := func() *testSymbol {
+		s := newTestSymbol(nextId)
+		nextId += 1
+		return s
+	}
+
+	// each encoder is duplicated for the multi-source decoder and a
+	// separate decoder, so that each decoder receives coded symbols only
+	// when it asks for them
+	multiEncs := make([]*Encoder[*testSymbol], nstreams)
+	encs := make([]*Encoder[*testSymbol], nstreams)
+	decs := make([]*Decoder[*testSymbol], nstreams)
+	multi := &MultiDecoder[*testSymbol]{}
+	multi.SetKey(testKeyA)
+	for i := range encs {
+		multiEncs[i] = &Encoder[*testSymbol]{}
+		multiEncs[i].SetKey(testKeyA)
+		encs[i] = &Encoder[*testSymbol]{}
+		encs[i].SetKey(testKeyA)
+		decs[i] = &Decoder[*testSymbol]{}
+		decs[i].SetKey(testKeyA)
+		if multi.AddStream(nil) != i {
+			t.Fatal("incorrect stream index")
+		}
+	}
+	addLocal := func(s *testSymbol) {
+		multi.AddSymbol(s)
+		for _, d := range decs {
+			d.AddSymbol(s)
+		}
+	}
+	addRemote := func(i int, s *testSymbol) {
+		multiEncs[i].AddSymbol(s)
+		encs[i].AddSymbol(s)
+	}
+	remote := make(map[uint64]struct{})
+	local := make(map[uint64]struct{})
+	unique := make([]map[uint64]struct{}, nstreams)
+	for i := 0; i < ncommon; i++ {
+		s := newSymbol()
+		addLocal(s)
+		for j := range encs {
+			addRemote(j, s)
+		}
+	}
+	for i := 0; i < nshared; i++ {
+		s := newSymbol()
+		remote[s.Hash()] = struct{}{}
+		for j := range encs {
+			addRemote(j, s)
+		}
+	}
+	for i := range encs {
+		unique[i] = make(map[uint64]struct{})
+		for j := 0; j < nunique; j++ {
+			s := newSymbol()
+			remote[s.Hash()] = struct{}{}
+			unique[i][s.Hash()] = struct{}{}
+			addRemote(i, s)
+		}
+	}
+	for i := 0; i < nlocal; i++ {
+		s := newSymbol()
+		local[s.Hash()] = struct{}{}
+		addLocal(s)
+	}
+
+	// feed every stream one coded symbol at a time; a decoded stream may
+	// need more coded symbols when symbols from other streams are shared
+	// with it
+	multiUsed, separateUsed := 0, 0
+	for round := 0; ; round++ {
+		done := round != 0
+		for i := range encs {
+			if round == 0 || !multi.Decoded(i) {
+				multi.AddCodedSymbol(i, multiEncs[i].ProduceNextCodedSymbol())
+				multiUsed += 1
+				done = false
+			}
+			if round == 0 || !decs[i].Decoded() {
+				decs[i].AddCodedSymbol(encs[i].ProduceNextCodedSymbol())
+				if err := decs[i].TryDecode(); err != nil {
+					t.Fatal(err)
+				}
+				separateUsed += 1
+				done = false
+			}
+		}
+		if err := multi.TryDecode(); err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			break
+		}
+	}
+
+	recovered := make(map[uint64]struct{})
+	for i := 0; i < nstreams; i++ {
+		for _, s := range multi.Remote(i) {
+			if _, there := remote[s.Hash]; !there {
+				t.Fatal("incorrect remote symbol decoded")
+			}
+			if _, there := recovered[s.Hash]; there {
+				t.Fatal("symbol reported by more than one stream")
+			}
+			recovered[s.Hash] = struct{}{}
+		}
+		// the local set of the stream includes the symbols of the other
+		// encoders recovered from their streams
+		for _, s := range multi.Local(i) {
+			_, isLocal := local[s.Hash]
+			_, isOwn := unique[i][s.Hash]
+			_, isRemote := remote[s.Hash]
+			if !isLocal && (isOwn || !isRemote) {
+				t.Fatal("incorrect local symbol decoded")
+			}
+		}
+		if len(multi.Local(i)) != nlocal+nunique*(nstreams-1) {
+			t.Errorf("stream %d decoded %d local symbols, expected %d", i, len(multi.Local(i)), nlocal+nunique*(nstreams-1))
+		}
+	}
+	if len(recovered) != len(remote) {
+		t.Errorf("recovered %d remote symbols, expected %d", len(recovered), len(remote))
+	}
+	if multiUsed >= separateUsed {
+		t.Errorf("multi-source decoding used %d coded symbols, not fewer than %d with separate decoders", multiUsed, separateUsed)
+	}
+}
@@ -0,0 +1,132 @@
+package riblt
+
+import (
+	"sync"
+)
+
+// ParallelEncoder produces the same coded symbols as Encoder, but splits its
+// symbols into partitions and produces coded symbols for each partition on
+// its own goroutine, merging the partial coded symbols afterwards. Producing
+// coded symbols in batches with ProduceNextCodedSymbols amortizes the cost of
+// coordinating the goroutines. It is safe for concurrent use.
+type ParallelEncoder[T Symbol[T]] struct {
+	lock     sync.Mutex
+	parts    []codingWindow[T]
+	partials [][]CodedSymbol[T] // scratch space for each partition
+	key      Key
+	seq      DegreeSequence
+}
+
+// NewParallelEncoder creates a ParallelEncoder with n partitions. n should
+// usually be the number of cores to use.
+func NewParallelEncoder[T Symbol[T]](n int) *ParallelEncoder[T] {
+	if n < 1 {
+		panic("parallel encoder needs at least one partition")
+	}
+	return &ParallelEncoder[T]{
+		parts:    make([]codingWindow[T], n),
+		partials: make([][]CodedSymbol[T], n),
+	}
+}
+
+// SetKey sets the key to rehash symbol hashes with. It must be called before
+// any symbol is added, and the key is kept across Reset.
+func (e *ParallelEncoder[T]) SetKey(k Key) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.key = k
+	for i := range e.parts {
+		e.parts[i].key = k
+	}
+}
+
+// SetDegreeSequence sets the degree sequence to map symbols to coded
+// symbols with. It must be called before any symbol is added, and is kept
+// across Reset.
+func (e *ParallelEncoder[T]) SetDegreeSequence(seq DegreeSequence) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.seq = seq
+	for i := range e.parts {
+		e.parts[i].seq = seq
+	}
+}
+
+func (e *ParallelEncoder[T]) AddSymbol(s T) {
+	e.AddHashedSymbol(HashedSymbol[T]{s, s.Hash()})
+}
+
+func (e *ParallelEncoder[T]) AddHashedSymbol(s HashedSymbol[T]) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	// partition by the rehashed hash so that an adversary cannot skew the
+	// partitions
+	pidx := e.key.rehash(s.Hash) % uint64(len(e.parts))
+	e.parts[pidx].addHashedSymbol(s)
+}
+
+// ProduceNextCodedSymbols fills dst with the next len(dst) coded symbols.
+func (e *ParallelEncoder[T]) ProduceNextCodedSymbols(dst []CodedSymbol[T]) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	n := len(dst)
+	np := len(e.parts)
+	wg := &sync.WaitGroup{}
+	wg.Add(np)
+	for pidx := range e.parts {
+		go func(pidx int) {
+			defer wg.Done()
+			buf := e.partials[pidx][:0]
+			for i := 0; i < n; i++ {
+				buf = append(buf, e.parts[pidx].applyWindow(CodedSymbol[T]{}, add))
+			}
+			e.partials[pidx] = buf
+		}(pidx)
+	}
+	wg.Wait()
+
+	// merge the partial coded symbols, splitting dst among the goroutines
+	wg.Add(np)
+	for g := 0; g < np; g++ {
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				c := CodedSymbol[T]{}
+				for pidx := range e.partials {
+					p := e.partials[pidx][i]
+					// skip empty partial coded symbols, whose sums are
+					// the default value of T
+					if p.count == 0 {
+						continue
+					}
+					c.sum = c.sum.XOR(p.sum)
+					c.count += p.count
+					c.checksum ^= p.checksum
+				}
+				dst[i] = c
+			}
+		}(n*g/np, n*(g+1)/np)
+	}
+	wg.Wait()
+
+	// do not keep the partial coded symbols alive
+	for pidx := range e.partials {
+		for i := range e.partials[pidx] {
+			e.partials[pidx][i] = CodedSymbol[T]{}
+		}
+	}
+}
+
+func (e *ParallelEncoder[T]) ProduceNextCodedSymbol() CodedSymbol[T] {
+	var dst [1]CodedSymbol[T]
+	e.ProduceNextCodedSymbols(dst[:])
+	return dst[0]
+}
+
+func (e *ParallelEncoder[T]) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i := range e.parts {
+		e.parts[i].reset()
+	}
+}
@@ -0,0 +1,63 @@
+package riblt
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParallelEncoderMatchesEncoder(t *testing.T) {
+	n := 5000
+	m := 3000
+	for _, np := range []int{1, 2, 3, 8} {
+		for _, k := range []Key{{}, testKeyA} {
+			enc := Encoder[*testSymbol]{}
+			enc.SetKey(k)
+			penc := NewParallelEncoder[*testSymbol](np)
+			penc.SetKey(k)
+			for i := 0; i < n; i++ {
+				enc.AddSymbol(newTestSymbol(uint64(i)))
+				penc.AddSymbol(newTestSymbol(uint64(i)))
+			}
+			// mix batches of different sizes with single coded symbols
+			produced := []CodedSymbol[*testSymbol]{}
+			for len(produced) < m {
+				batch := make([]CodedSymbol[*testSymbol], len(produced)%97+1)
+				penc.ProduceNextCodedSymbols(batch)
+				produced = append(produced, batch...)
+				produced = append(produced, penc.ProduceNextCodedSymbol())
+			}
+			for i, c := range produced {
+				if !equalCodedSymbols(c, enc.ProduceNextCodedSymbol()) {
+					t.Fatalf("coded symbol %d differs from sequential encoder with %d partitions", i, np)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelEncoderConcurrentAdd(t *testing.T) {
+	n := 4000
+	enc := Encoder[*testSymbol]{}
+	penc := NewParallelEncoder[*testSymbol](4)
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < n; i += 4 {
+				penc.AddSymbol(newTestSymbol(uint64(i)))
+			}
+		}(g)
+	}
+	for i := 0; i < n; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	wg.Wait()
+	buf := make([]CodedSymbol[*testSymbol], 1000)
+	penc.ProduceNextCodedSymbols(buf)
+	for i, c := range buf {
+		if !equalCodedSymbols(c, enc.ProduceNextCodedSymbol()) {
+			t.Fatalf("coded symbol %d differs from sequential encoder", i)
+		}
+	}
+}
@@ -4,6 +4,128 @@ import (
 	"math"
 )
 
+// DegreeSequence decides the coded symbols that each symbol is mapped to.
+// Every symbol is mapped to coded symbol 0. After coded symbol last, a symbol
+// is mapped to coded symbol NextIndex(last, r), where r is drawn uniformly
+// from [0, 1) by a PRNG seeded with the hash of the symbol. The encoder and
+// the decoder must use the same sequence.
+type DegreeSequence interface {
+	// NextIndex returns the index of the next coded symbol after last that a
+	// symbol is mapped to. It must be larger than last.
+	NextIndex(last uint64, r float64) uint64
+	// Probability returns the probability that a symbol is mapped to coded
+	// symbol i.
+	Probability(i uint64) float64
+}
+
+// Harmonic is the degree sequence that maps a symbol to coded symbol i with
+// probability 1/(1+i/alpha). A nil DegreeSequence means Harmonic(2), which
+// decodes a difference of size d with about 1.35d coded symbols when d is
+// large. A larger alpha puts more symbols in each coded symbol, and a
+// smaller one fewer.
+type Harmonic float64
+
+// NextIndex of Harmonic(2) uses the same approximation as a nil
+// DegreeSequence, which maps symbols to the first few coded symbols a few
+// percent less often than it should. Other values of alpha are exact.
+func (h Harmonic) NextIndex(last uint64, r float64) uint64 {
+	if h == 2 {
+		return last + uint64(math.Ceil((float64(last)+1.5)*(1/math.Sqrt(1-r)-1)))
+	}
+	// The probability that a symbol skips coded symbols j+1 to j+x, where
+	// j is last, is the product of i/(i+alpha) over them, which is
+	// Gamma(j+x+1)Gamma(j+1+alpha)/(Gamma(j+1)Gamma(j+x+1+alpha)). Find
+	// the smallest x where it is at most 1-r.
+	alpha := float64(h)
+	j := float64(last)
+	lu := math.Log1p(-r)
+	lg := func(x float64) float64 {
+		v, _ := math.Lgamma(x)
+		return v
+	}
+	base := lg(j+1+alpha) - lg(j+1)
+	skipped := func(x uint64) bool {
+		return lg(j+float64(x)+1)+base-lg(j+float64(x)+1+alpha) > lu
+	}
+	lo, hi := uint64(0), uint64(1)
+	for skipped(hi) {
+		lo = hi
+		hi *= 2
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if skipped(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return last + hi
+}
+
+func (h Harmonic) Probability(i uint64) float64 {
+	return 1 / (1 + float64(i)/float64(h))
+}
+
+// IBLTPrefix is a degree sequence that starts with an IBLT-like prefix:
+// after coded symbol 0, a symbol is mapped to each of the next Cells-1
+// coded symbols with probability Degree/(Cells-1), and after that as in
+// Tail, or Harmonic(2) if Tail is nil. Sending the prefix as a batch suits
+// small differences, where the prefix alone often decodes.
+type IBLTPrefix struct {
+	Cells  uint64
+	Degree float64
+	Tail   DegreeSequence
+}
+
+func (p IBLTPrefix) tail() DegreeSequence {
+	if p.Tail == nil {
+		return Harmonic(2)
+	}
+	return p.Tail
+}
+
+func (p IBLTPrefix) cellProbability() float64 {
+	q := p.Degree / float64(p.Cells-1)
+	if q > 1 {
+		return 1
+	}
+	return q
+}
+
+func (p IBLTPrefix) NextIndex(last uint64, r float64) uint64 {
+	if last+1 >= p.Cells {
+		return p.tail().NextIndex(last, r)
+	}
+	// geometric skip over the cells of the prefix
+	q := p.cellProbability()
+	if q == 1 {
+		return last + 1
+	}
+	next := last + 1 + uint64(math.Floor(math.Log1p(-r)/math.Log1p(-q)))
+	if next < p.Cells {
+		return next
+	}
+	// r is uniform in [f, 1) given that the symbol skips the rest of the
+	// prefix; rescale it to draw from the tail
+	f := 1 - math.Pow(1-q, float64(p.Cells-1-last))
+	rt := (r - f) / (1 - f)
+	if rt < 0 {
+		rt = 0
+	}
+	return p.tail().NextIndex(p.Cells-1, rt)
+}
+
+func (p IBLTPrefix) Probability(i uint64) float64 {
+	if i == 0 {
+		return 1
+	}
+	if i < p.Cells {
+		return p.cellProbability()
+	}
+	return p.tail().Probability(i)
+}
+
 type randomMapping struct {
 	prng uint64		// PRNG state
 	lastIdx uint64	// the last index the symbol was mapped to
@@ -14,10 +136,17 @@ const (
 	minstd_a uint64 = 16807
 )
 
-// degree sequence is 1/(1+idx/2)
-func (s *randomMapping) nextIndex() uint64 {
+// nextIndex advances the mapping to the next coded symbol that the symbol is
+// mapped to under seq, or Harmonic(2) if seq is nil.
+func (s *randomMapping) nextIndex(seq DegreeSequence) uint64 {
 	r := s.prng * 0xda942042e4dd58b5	// can we prove this is fine, assuming the multiplier is coprime to 2^64?
 	s.prng = r
+	xm := float64(r) / (1 << 64)
+	if seq != nil {
+		s.lastIdx = seq.NextIndex(s.lastIdx, xm)
+		return s.lastIdx
+	}
+	// degree sequence is 1/(1+idx/2)
 	// m: minstd_m
 	// x: steps to advance
 	// r: random integer in [0, m)
@@ -35,7 +164,6 @@ func (s *randomMapping) nextIndex() uint64 {
 	//
 	// As an approximation, we can use
 	// x = (j+1.5)(1/sqrt(1-x/m) - 1)
-	xm := float64(r) / (1 << 64)
 	s.lastIdx += uint64(math.Ceil((float64(s.lastIdx)+1.5)*(1/math.Sqrt(1-xm)-1)))
 	return s.lastIdx
 }
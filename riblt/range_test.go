@@ -0,0 +1,110 @@
+package riblt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestProduceCodedSymbolRange(t *testing.T) {
+	enc := &Encoder[*testSymbol]{}
+	for i := 0; i < 200; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	seq := []CodedSymbol[*testSymbol]{}
+	for i := 0; i < 100; i++ {
+		seq = append(seq, enc.ProduceNextCodedSymbol())
+	}
+	// producing a range must not disturb the sequential stream
+	r := enc.ProduceCodedSymbolRange(50, 300)
+	for i := 100; i < 300; i++ {
+		seq = append(seq, enc.ProduceNextCodedSymbol())
+	}
+	for i := range r {
+		if !equalCodedSymbols(r[i], seq[50+i]) {
+			t.Fatalf("coded symbol %d of the range differs", 50+i)
+		}
+	}
+	for _, r := range [][2]int{{10, 10}, {10, 5}, {-1, 5}} {
+		if cs := enc.ProduceCodedSymbolRange(r[0], r[1]); len(cs) != 0 {
+			t.Errorf("range %v has %d coded symbols", r, len(cs))
+		}
+	}
+}
+
+func TestOutOfOrderDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const nenc = 3
+	const block = 16
+	encs := []*Encoder[*testSymbol]{}
+	for k := 0; k < nenc; k++ {
+		enc := &Encoder[*testSymbol]{}
+		enc.SetKey(testKeyA)
+		for i := 0; i < 1000; i++ {
+			enc.AddSymbol(newTestSymbol(uint64(i)))
+		}
+		encs = append(encs, enc)
+	}
+	dec := &Decoder[*testSymbol]{}
+	dec.SetKey(testKeyA)
+	for i := 0; i < 1000; i++ {
+		if i%10 != 0 {
+			dec.AddSymbol(newTestSymbol(uint64(i)))
+		}
+	}
+	for i := 0; i < 50; i++ {
+		dec.AddSymbol(newTestSymbol(uint64(5000 + i)))
+	}
+	// each encoder produces every nenc-th block, and the blocks arrive in
+	// a random order among each round
+	n := 0
+	for round := 0; n == 0 || !dec.Decoded(); round++ {
+		if round > 100 {
+			t.Fatal("failed to decode")
+		}
+		order := rng.Perm(nenc)
+		for _, k := range order {
+			start := (round*nenc + k) * block
+			cs := encs[k].ProduceCodedSymbolRange(start, start+block)
+			for _, i := range rng.Perm(block) {
+				dec.AddCodedSymbolAt(start+i, cs[i])
+			}
+			n += block
+			if round == 1 {
+				// local symbols added while coded symbols are missing
+				dec.AddSymbol(newTestSymbol(uint64(6000 + k)))
+			}
+			if rng.Intn(3) == 0 {
+				data, err := dec.Marshal(testSymbolCodec{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec = &Decoder[*testSymbol]{}
+				dec.SetKey(testKeyA)
+				if err := dec.Unmarshal(testSymbolCodec{}, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// a duplicate delivery is ignored
+			dec.AddCodedSymbolAt(start, cs[0])
+			if err := dec.TryDecode(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expected := make(map[uint64]struct{})
+	for i := 0; i < 1000; i += 10 {
+		expected[newTestSymbol(uint64(i)).Hash()] = struct{}{}
+	}
+	if len(dec.Remote()) != len(expected) {
+		t.Errorf("%d remote symbols, expected %d", len(dec.Remote()), len(expected))
+	}
+	for _, s := range dec.Remote() {
+		if _, there := expected[s.Hash]; !there {
+			t.Error("wrong remote symbol")
+		}
+	}
+	if len(dec.Local()) != 50+nenc {
+		t.Errorf("%d local symbols, expected %d", len(dec.Local()), 50+nenc)
+	}
+	t.Logf("decoded with %d coded symbols", n)
+}
@@ -0,0 +1,187 @@
+package riblt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func checkDecoded(t *testing.T, dec *Decoder[*testSymbol], remote, local map[uint64]struct{}) {
+	t.Helper()
+	if !dec.Decoded() {
+		t.Fatal("decoder not marked as decoded")
+	}
+	if len(dec.Remote()) != len(remote) || len(dec.Local()) != len(local) {
+		t.Fatalf("decoded %d remote and %d local symbols, expected %d and %d", len(dec.Remote()), len(dec.Local()), len(remote), len(local))
+	}
+	for _, v := range dec.Remote() {
+		if _, there := remote[v.Hash]; !there {
+			t.Fatal("incorrect remote symbol decoded")
+		}
+	}
+	for _, v := range dec.Local() {
+		if _, there := local[v.Hash]; !there {
+			t.Fatal("incorrect local symbol decoded")
+		}
+	}
+}
+
+func TestEncoderRemoveMatchesFreshEncoder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	enc := Encoder[*testSymbol]{}
+	set := make(map[uint64]*testSymbol)
+	for i := 0; i < 3000; i++ {
+		if len(set) > 0 && rng.Intn(3) == 0 {
+			for h, s := range set {
+				if _, err := enc.RemoveSymbol(s); err != nil {
+					t.Fatal(err)
+				}
+				delete(set, h)
+				break
+			}
+		} else {
+			s := newTestSymbol(uint64(i))
+			enc.AddSymbol(s)
+			set[s.Hash()] = s
+		}
+	}
+	if _, err := enc.RemoveSymbol(newTestSymbol(1 << 40)); err != ErrNotFound {
+		t.Error("failed to report removing a symbol not in the set")
+	}
+	fresh := Encoder[*testSymbol]{}
+	for _, s := range set {
+		fresh.AddSymbol(s)
+	}
+	for i := 0; i < 2000; i++ {
+		if !equalCodedSymbols(enc.ProduceNextCodedSymbol(), fresh.ProduceNextCodedSymbol()) {
+			t.Fatalf("coded symbol %d differs from encoder built from the remaining set", i)
+		}
+	}
+}
+
+func TestRemoveAfterProduction(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	encSet := make(map[uint64]*testSymbol)
+	decSet := make(map[uint64]*testSymbol)
+	var nextId uint64
+	newSymbol := func() *testSymbol {
+		s := newTestSymbol(nextId)
+		nextId += 1
+		return s
+	}
+	for i := 0; i < 200; i++ {
+		s := newSymbol()
+		enc.AddSymbol(s)
+		encSet[s.Hash()] = s
+	}
+	for i := 0; i < 200; i++ {
+		s := newSymbol()
+		dec.AddSymbol(s)
+		decSet[s.Hash()] = s
+	}
+	for i := 0; i < 2000; i++ {
+		s := newSymbol()
+		enc.AddSymbol(s)
+		dec.AddSymbol(s)
+		encSet[s.Hash()] = s
+		decSet[s.Hash()] = s
+	}
+
+	// corrections are delivered with a delay of a few coded symbols
+	type delayed struct {
+		c  Correction[*testSymbol]
+		at int
+	}
+	inflight := []delayed{}
+	ncw := 0
+	for ncw < 100 || !dec.Decoded() || len(inflight) != 0 {
+		if ncw < 600 && rng.Intn(4) == 0 {
+			// evict a random symbol from the encoder
+			for h, s := range encSet {
+				c, err := enc.RemoveSymbol(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				inflight = append(inflight, delayed{c, ncw + rng.Intn(20)})
+				delete(encSet, h)
+				break
+			}
+		}
+		if ncw < 600 && rng.Intn(4) == 0 {
+			// evict a random symbol from the decoder
+			for h, s := range decSet {
+				if err := dec.RemoveSymbol(s); err != nil {
+					t.Fatal(err)
+				}
+				delete(decSet, h)
+				break
+			}
+		}
+		if ncw < 600 && rng.Intn(4) == 0 {
+			// add a new symbol to the decoder
+			s := newSymbol()
+			dec.AddSymbol(s)
+			decSet[s.Hash()] = s
+		}
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		ncw += 1
+		for i := 0; i < len(inflight); {
+			if inflight[i].at <= ncw {
+				dec.ApplyCorrection(inflight[i].c)
+				inflight[i] = inflight[len(inflight)-1]
+				inflight = inflight[:len(inflight)-1]
+			} else {
+				i += 1
+			}
+		}
+		dec.TryDecode()
+		if ncw > 100000 {
+			t.Fatal("failed to decode")
+		}
+	}
+
+	remote := make(map[uint64]struct{})
+	local := make(map[uint64]struct{})
+	for h := range encSet {
+		if _, there := decSet[h]; !there {
+			remote[h] = struct{}{}
+		}
+	}
+	for h := range decSet {
+		if _, there := encSet[h]; !there {
+			local[h] = struct{}{}
+		}
+	}
+	checkDecoded(t, &dec, remote, local)
+	t.Logf("%d codewords until fully decoded", ncw)
+}
+
+func TestAddDecodedRemoteSymbol(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	for i := 0; i < 100; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for !dec.Decoded() || len(dec.cs) == 0 {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	// the decoder learns the first half of the remote symbols
+	learned := append([]HashedSymbol[*testSymbol]{}, dec.Remote()[:50]...)
+	for _, s := range learned {
+		dec.AddHashedSymbol(s)
+	}
+	for i := 0; i < 100; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	remote := make(map[uint64]struct{})
+	for i := 0; i < 100; i++ {
+		remote[newTestSymbol(uint64(i)).Hash()] = struct{}{}
+	}
+	for _, s := range learned {
+		delete(remote, s.Hash)
+	}
+	checkDecoded(t, &dec, remote, nil)
+}
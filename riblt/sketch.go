@@ -1,23 +1,43 @@
 package riblt
 
+// Sketch is a fixed number of coded symbols of a set. Adding or removing a
+// symbol updates O(log n) coded symbols of a sketch of size n, so a sketch
+// can be maintained along with a set that changes over time.
 type Sketch[T Symbol[T]] []CodedSymbol[T]
 
-func (s Sketch[T]) AddHashedSymbol(t HashedSymbol[T]) {
-	m := randomMapping{t.Hash, 0}
+func (s Sketch[T]) applyHashedSymbol(t HashedSymbol[T], k Key, seq DegreeSequence, direction int64) {
+	kh := k.rehash(t.Hash)
+	m := randomMapping{kh, 0}
 	for int(m.lastIdx) < len(s) {
 		idx := m.lastIdx
 		s[idx].sum = s[idx].sum.XOR(t.Symbol)
-		s[idx].count += 1
-		s[idx].checksum ^= t.Hash
-		m.nextIndex()
+		s[idx].count += direction
+		s[idx].checksum ^= kh
+		m.nextIndex(seq)
 	}
 }
 
+func (s Sketch[T]) AddHashedSymbol(t HashedSymbol[T]) {
+	s.applyHashedSymbol(t, Key{}, nil, add)
+}
+
 func (s Sketch[T]) AddSymbol(t T) {
 	hs := HashedSymbol[T]{t, t.Hash()}
 	s.AddHashedSymbol(hs)
 }
 
+// RemoveHashedSymbol removes t from the sketch. t must have been added;
+// otherwise, the sketch becomes that of a set with t missing from the
+// remote side.
+func (s Sketch[T]) RemoveHashedSymbol(t HashedSymbol[T]) {
+	s.applyHashedSymbol(t, Key{}, nil, remove)
+}
+
+func (s Sketch[T]) RemoveSymbol(t T) {
+	hs := HashedSymbol[T]{t, t.Hash()}
+	s.RemoveHashedSymbol(hs)
+}
+
 func (s Sketch[T]) Subtract(s2 Sketch[T]) Sketch[T] {
 	if len(s) != len(s2) {
 		panic("subtracting sketches of different sizes")
@@ -31,11 +51,107 @@ func (s Sketch[T]) Subtract(s2 Sketch[T]) Sketch[T] {
 	return s
 }
 
-func (s Sketch[T]) Decode() ([]HashedSymbol[T], []HashedSymbol[T], bool) {
+func (s Sketch[T]) decode(k Key, seq DegreeSequence) ([]HashedSymbol[T], []HashedSymbol[T], bool) {
 	dec := Decoder[T]{}
+	dec.SetKey(k)
+	dec.SetDegreeSequence(seq)
 	for _, c := range s {
 		dec.AddCodedSymbol(c)
 	}
 	dec.TryDecode()
 	return dec.Remote(), dec.Local(), dec.Decoded()
 }
+
+// Decode decodes s, which is usually the difference of two sketches. It may
+// modify the sums of s in place when XOR of T does so.
+func (s Sketch[T]) Decode() ([]HashedSymbol[T], []HashedSymbol[T], bool) {
+	return s.decode(Key{}, nil)
+}
+
+func (s Sketch[T]) decodeIncremental(k Key, seq DegreeSequence) ([]HashedSymbol[T], []HashedSymbol[T], int, bool) {
+	dec := Decoder[T]{}
+	dec.SetKey(k)
+	dec.SetDegreeSequence(seq)
+	for i, c := range s {
+		dec.AddCodedSymbol(c)
+		dec.TryDecode()
+		if dec.Decoded() {
+			return dec.Remote(), dec.Local(), i+1, true
+		}
+	}
+	return dec.Remote(), dec.Local(), len(s), false
+}
+
+// DecodeIncremental decodes s one coded symbol at a time, and stops at the
+// shortest prefix of s that decodes. It returns the length of that prefix,
+// or len(s) if s does not decode. Like Decode, it may modify the sums of s
+// in place.
+func (s Sketch[T]) DecodeIncremental() ([]HashedSymbol[T], []HashedSymbol[T], int, bool) {
+	return s.decodeIncremental(Key{}, nil)
+}
+
+// Prefix returns the first n coded symbols of s, which are the sketch of
+// size n of the same set. The prefix shares memory with s, so subtract from
+// a copy of it when s is to be kept.
+func (s Sketch[T]) Prefix(n int) Sketch[T] {
+	return s[:n:n]
+}
+
+// WithKey returns a view of s that rehashes symbol hashes with k. Sketches
+// to be subtracted from each other must use the same key.
+func (s Sketch[T]) WithKey(k Key) KeyedSketch[T] {
+	return KeyedSketch[T]{s, k, nil}
+}
+
+// WithDegreeSequence returns a view of s that maps symbols to coded symbols
+// with seq. Sketches to be subtracted from each other must use the same
+// degree sequence.
+func (s Sketch[T]) WithDegreeSequence(seq DegreeSequence) KeyedSketch[T] {
+	return KeyedSketch[T]{s, Key{}, seq}
+}
+
+// KeyedSketch is a view of a sketch with a key and a degree sequence, which
+// is Harmonic(2) if Degrees is nil.
+type KeyedSketch[T Symbol[T]] struct {
+	Sketch[T]
+	Key Key
+	Degrees DegreeSequence
+}
+
+func (s KeyedSketch[T]) WithKey(k Key) KeyedSketch[T] {
+	return KeyedSketch[T]{s.Sketch, k, s.Degrees}
+}
+
+func (s KeyedSketch[T]) WithDegreeSequence(seq DegreeSequence) KeyedSketch[T] {
+	return KeyedSketch[T]{s.Sketch, s.Key, seq}
+}
+
+func (s KeyedSketch[T]) AddHashedSymbol(t HashedSymbol[T]) {
+	s.Sketch.applyHashedSymbol(t, s.Key, s.Degrees, add)
+}
+
+func (s KeyedSketch[T]) AddSymbol(t T) {
+	hs := HashedSymbol[T]{t, t.Hash()}
+	s.AddHashedSymbol(hs)
+}
+
+func (s KeyedSketch[T]) RemoveHashedSymbol(t HashedSymbol[T]) {
+	s.Sketch.applyHashedSymbol(t, s.Key, s.Degrees, remove)
+}
+
+func (s KeyedSketch[T]) RemoveSymbol(t T) {
+	hs := HashedSymbol[T]{t, t.Hash()}
+	s.RemoveHashedSymbol(hs)
+}
+
+func (s KeyedSketch[T]) Decode() ([]HashedSymbol[T], []HashedSymbol[T], bool) {
+	return s.Sketch.decode(s.Key, s.Degrees)
+}
+
+func (s KeyedSketch[T]) DecodeIncremental() ([]HashedSymbol[T], []HashedSymbol[T], int, bool) {
+	return s.Sketch.decodeIncremental(s.Key, s.Degrees)
+}
+
+func (s KeyedSketch[T]) Prefix(n int) KeyedSketch[T] {
+	return KeyedSketch[T]{s.Sketch.Prefix(n), s.Key, s.Degrees}
+}
@@ -0,0 +1,148 @@
+package riblt
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSketchRemoveMatchesFreshSketch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := make(Sketch[*testSymbol], 200).WithKey(testKeyA)
+	set := make(map[uint64]*testSymbol)
+	for i := 0; i < 3000; i++ {
+		if len(set) > 0 && rng.Intn(3) == 0 {
+			for h, sym := range set {
+				s.RemoveSymbol(sym)
+				delete(set, h)
+				break
+			}
+		} else {
+			sym := newTestSymbol(uint64(i))
+			s.AddSymbol(sym)
+			set[sym.Hash()] = sym
+		}
+	}
+	fresh := make(Sketch[*testSymbol], 200).WithKey(testKeyA)
+	for _, sym := range set {
+		fresh.AddSymbol(sym)
+	}
+	for i := range s.Sketch {
+		if s.Sketch[i].count != fresh.Sketch[i].count || s.Sketch[i].checksum != fresh.Sketch[i].checksum {
+			t.Fatalf("coded symbol %d differs from sketch built from the remaining set", i)
+		}
+		// sums of empty coded symbols may be zero or nil
+		if s.Sketch[i].count != 0 && *s.Sketch[i].sum != *fresh.Sketch[i].sum {
+			t.Fatalf("coded symbol %d differs from sketch built from the remaining set", i)
+		}
+	}
+}
+
+func TestSketchFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sketch")
+	s1 := make(Sketch[*testSymbol], 100)
+	s2 := make(Sketch[*testSymbol], 100)
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(uint64(i))
+		s1.AddSymbol(s)
+		if i >= 10 {
+			s2.AddSymbol(s)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		s1.RemoveSymbol(newTestSymbol(uint64(i)))
+	}
+	if err := s1.WriteFile(name, testSymbolCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	// overwrite an existing snapshot
+	s1.AddSymbol(newTestSymbol(1000))
+	if err := s1.WriteFile(name, testSymbolCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadSketchFile[*testSymbol](name, testSymbolCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range s1 {
+		if !equalCodedSymbols(s1[i], loaded[i]) {
+			t.Fatalf("coded symbol %d differs after loading", i)
+		}
+	}
+	remote, local, ok := s2.Subtract(loaded).Decode()
+	if !ok {
+		t.Fatal("failed to decode against loaded snapshot")
+	}
+	if len(remote) != 0 || len(local) != 1 {
+		t.Errorf("decoded %d remote and %d local symbols, expected 0 and 1", len(remote), len(local))
+	}
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in the directory, expected 1", len(entries))
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSketchFile[*testSymbol](name, testSymbolCodec{}); err != ErrChecksum {
+		t.Errorf("loading corrupted file returned %v, expected ErrChecksum", err)
+	}
+}
+
+func TestSketchPrefix(t *testing.T) {
+	// every coded symbol of the sketches is nonempty, as testSymbol does not
+	// support XOR with nil
+	large := make(Sketch[*testSymbol], 200).WithKey(testKeyA)
+	for i := 0; i < 1000; i++ {
+		large.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for _, ndiff := range []int{0, 5, 50} {
+		// Subtract modifies the sums of the receiver only, so large is
+		// kept intact, but decoding modifies the sums of the sketch, so we
+		// build the difference anew for every decoding
+		newDiff := func() KeyedSketch[*testSymbol] {
+			diff := make(Sketch[*testSymbol], 200).WithKey(testKeyA)
+			for i := ndiff; i < 1000; i++ {
+				diff.AddSymbol(newTestSymbol(uint64(i)))
+			}
+			diff.Subtract(large.Sketch)
+			return diff
+		}
+		_, local, n, ok := newDiff().DecodeIncremental()
+		if !ok {
+			t.Fatalf("failed to decode %d differences", ndiff)
+		}
+		if len(local) != ndiff {
+			t.Errorf("decoded %d symbols, expected %d", len(local), ndiff)
+		}
+		if _, _, ok := newDiff().Prefix(n).Decode(); !ok {
+			t.Errorf("failed to decode prefix of length %d reported by DecodeIncremental", n)
+		}
+		if n > 1 {
+			if _, _, ok := newDiff().Prefix(n-1).Decode(); ok {
+				t.Errorf("prefix of length %d decodes, shorter than %d reported by DecodeIncremental", n-1, n)
+			}
+		}
+
+		// a prefix of the large sketch is a sketch of that size of the
+		// same set
+		small := make(Sketch[*testSymbol], n).WithKey(testKeyA)
+		for i := ndiff; i < 1000; i++ {
+			small.AddSymbol(newTestSymbol(uint64(i)))
+		}
+		small.Subtract(large.Prefix(n).Sketch)
+		if _, _, ok := small.Decode(); !ok {
+			t.Errorf("failed to decode prefix of length %d against a sketch of that size", n)
+		}
+		t.Logf("%d differences decoded with a prefix of %d coded symbols", ndiff, n)
+	}
+}
@@ -0,0 +1,326 @@
+package riblt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Snapshots of encoders and decoders let a session continue from where it
+// stopped, e.g., after a connection drops. A snapshot does not hold the key
+// or the degree sequence, which must be set to the same ones before loading
+// it. Mappings are saved as the index of the next coded symbol only, and are
+// replayed from the hash when loading, which also catches most mismatches of
+// the key or the degree sequence.
+
+// SnapshotVersion is the version of the snapshot format, and the first byte
+// of every snapshot. It is bumped whenever the layout changes, and loading a
+// snapshot of another version fails with VersionError. Version 1 did not
+// record missing coded symbols of decoders.
+const SnapshotVersion = 2
+
+// maxSnapshotIndex bounds the coded symbol indices in a snapshot, far above
+// what any session reaches, so that a corrupt snapshot cannot make us replay
+// mappings to near the end of the uint64 range.
+const maxSnapshotIndex = 1 << 40
+
+// appendBinary appends the symbols of e and their mappings to buf.
+func (e *codingWindow[T]) appendBinary(codec SymbolCodec[T], buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(e.nextIdx))
+	buf = binary.AppendUvarint(buf, uint64(len(e.symbols)))
+	for i, s := range e.symbols {
+		buf = binary.LittleEndian.AppendUint64(buf, s.Hash)
+		buf = binary.AppendUvarint(buf, e.mappings[i].lastIdx)
+		buf = codec.AppendSymbol(buf, s.Symbol)
+	}
+	return buf
+}
+
+// readBinary replaces the content of e with the symbols read from r.
+func (e *codingWindow[T]) readBinary(codec SymbolCodec[T], r *bytes.Reader) error {
+	e.reset()
+	nextIdx, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	// every symbol takes at least nine bytes
+	if n > uint64(r.Len()) || nextIdx > maxSnapshotIndex {
+		return ErrMalformed
+	}
+	e.nextIdx = int(nextIdx)
+	for i := uint64(0); i < n; i++ {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return err
+		}
+		hash := binary.LittleEndian.Uint64(h[:])
+		lastIdx, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		sym, err := codec.ReadSymbol(r)
+		if err != nil {
+			return err
+		}
+		kh := e.key.rehash(hash)
+		m, err := replayMapping(kh, nextIdx, lastIdx, e.seq)
+		if err != nil {
+			return err
+		}
+		e.addHashedSymbolWithMapping(HashedSymbol[T]{sym, hash}, kh, m)
+	}
+	return nil
+}
+
+// replayMapping returns the mapping seeded with checksum advanced to
+// lastIdx, which must be the first index it maps to from nextIdx on, as a
+// symbol that has been applied to the coded symbols before nextIdx is. The
+// replay stops at nextIdx, so a corrupt lastIdx does not make it run long.
+func replayMapping(checksum uint64, nextIdx uint64, lastIdx uint64, seq DegreeSequence) (randomMapping, error) {
+	m := randomMapping{checksum, 0}
+	if lastIdx < nextIdx {
+		return m, ErrMalformed
+	}
+	for m.lastIdx < nextIdx {
+		m.nextIndex(seq)
+	}
+	if m.lastIdx != lastIdx {
+		return m, ErrMalformed
+	}
+	return m, nil
+}
+
+// Marshal saves the state of e, using codec to serialize its symbols.
+func (e *Encoder[T]) Marshal(codec SymbolCodec[T]) ([]byte, error) {
+	buf := []byte{SnapshotVersion}
+	return (*codingWindow[T])(e).appendBinary(codec, buf), nil
+}
+
+// Unmarshal restores the state saved by Marshal into e, which
+// then produces the coded symbols that would have followed. The key and the
+// degree sequence of e are kept.
+func (e *Encoder[T]) Unmarshal(codec SymbolCodec[T], data []byte) error {
+	if len(data) == 0 {
+		return ErrMalformed
+	}
+	if data[0] != SnapshotVersion {
+		return VersionError{data[0]}
+	}
+	r := bytes.NewReader(data[1:])
+	if err := (*codingWindow[T])(e).readBinary(codec, r); err != nil {
+		e.Reset()
+		return malformed(err)
+	}
+	if r.Len() != 0 {
+		e.Reset()
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Marshal saves the state of d, using codec to serialize symbols.
+// Errors reported by TryDecode are not saved.
+func (d *Decoder[T]) Marshal(codec SymbolCodec[T]) ([]byte, error) {
+	buf := []byte{SnapshotVersion}
+	if d.sealed {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(d.base))
+	buf = binary.AppendUvarint(buf, uint64(len(d.cs)))
+	for _, c := range d.cs {
+		buf = c.appendBinary(codec, buf)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(d.dirty)))
+	for _, idx := range d.dirty {
+		buf = binary.AppendUvarint(buf, uint64(idx))
+	}
+	nmissing := 0
+	for _, c := range d.cs {
+		if c.missing {
+			nmissing += 1
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(nmissing))
+	for i, c := range d.cs {
+		if c.missing {
+			buf = binary.AppendUvarint(buf, uint64(d.base+i))
+			if c.applied {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		}
+	}
+	buf = d.window.appendBinary(codec, buf)
+	buf = d.local.appendBinary(codec, buf)
+	buf = d.remote.appendBinary(codec, buf)
+	buf = binary.AppendUvarint(buf, uint64(len(d.corrections)))
+	for _, pc := range d.corrections {
+		buf = binary.LittleEndian.AppendUint64(buf, pc.symbol.Hash)
+		buf = binary.AppendUvarint(buf, pc.mapping.lastIdx)
+		buf = binary.AppendUvarint(buf, uint64(pc.until))
+		buf = codec.AppendSymbol(buf, pc.symbol.Symbol)
+	}
+	return buf, nil
+}
+
+// Unmarshal restores the state saved by Marshal into d, which
+// then continues with the coded symbol that would have followed. The key,
+// the degree sequence and the budget of d are kept.
+func (d *Decoder[T]) Unmarshal(codec SymbolCodec[T], data []byte) error {
+	if len(data) == 0 {
+		return ErrMalformed
+	}
+	if data[0] != SnapshotVersion {
+		return VersionError{data[0]}
+	}
+	d.Reset()
+	r := bytes.NewReader(data[1:])
+	if err := d.readBinary(codec, r); err != nil {
+		d.Reset()
+		return malformed(err)
+	}
+	if r.Len() != 0 {
+		d.Reset()
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (d *Decoder[T]) readBinary(codec SymbolCodec[T], r *bytes.Reader) error {
+	sealed, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if sealed > 1 {
+		return ErrMalformed
+	}
+	base, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	ncs, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	// every coded symbol takes at least two bytes
+	if ncs > uint64(r.Len()) || base > maxSnapshotIndex {
+		return ErrMalformed
+	}
+	d.base = int(base)
+	for i := uint64(0); i < ncs; i++ {
+		c := CodedSymbol[T]{}
+		if err := c.readBinary(codec, r); err != nil {
+			return err
+		}
+		d.cs = append(d.cs, receivedSymbol[T]{CodedSymbol: c})
+	}
+	ndirty, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if ndirty > ncs {
+		return ErrMalformed
+	}
+	for i := uint64(0); i < ndirty; i++ {
+		idx, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if idx < base || idx >= base+ncs || d.cs[idx-base].dirty {
+			return ErrMalformed
+		}
+		d.cs[idx-base].dirty = true
+		d.dirty = append(d.dirty, int(idx))
+	}
+	nmissing, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if nmissing > ncs {
+		return ErrMalformed
+	}
+	for i := uint64(0); i < nmissing; i++ {
+		idx, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		applied, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if idx < base || idx >= base+ncs || applied > 1 {
+			return ErrMalformed
+		}
+		c := &d.cs[idx-base]
+		if c.missing || c.dirty {
+			return ErrMalformed
+		}
+		c.missing = true
+		c.applied = applied == 1
+		d.missing += 1
+		if !c.applied {
+			// the codec may not restore the default value of T
+			c.CodedSymbol = CodedSymbol[T]{}
+		}
+	}
+	for _, c := range d.cs {
+		if !c.missing && !c.isZero() {
+			d.pending += 1
+		}
+	}
+	for _, w := range []*codingWindow[T]{&d.window, &d.local, &d.remote} {
+		if err := w.readBinary(codec, r); err != nil {
+			return err
+		}
+		// the windows have been applied to every coded symbol received
+		if w.nextIdx != d.received() {
+			return ErrMalformed
+		}
+	}
+	ncorr, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if ncorr > uint64(r.Len()) {
+		return ErrMalformed
+	}
+	for i := uint64(0); i < ncorr; i++ {
+		var h [8]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return err
+		}
+		kh := binary.LittleEndian.Uint64(h[:])
+		lastIdx, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		until, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		sym, err := codec.ReadSymbol(r)
+		if err != nil {
+			return err
+		}
+		if until > maxSnapshotIndex || lastIdx >= until {
+			return ErrMalformed
+		}
+		m, err := replayMapping(kh, uint64(d.received()), lastIdx, d.seq)
+		if err != nil {
+			return err
+		}
+		d.corrections = append(d.corrections, pendingCorrection[T]{HashedSymbol[T]{sym, kh}, m, int(until)})
+	}
+	d.sealed = sealed == 1
+	if d.sealed {
+		d.window.index = nil
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package riblt
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// runSession reconciles a remote set of 300 symbols, one of which is
+// removed during the session, against a local set,
+// and saves and restores the encoder and the decoder before each coded
+// symbol with probability p. It returns the number of coded symbols used
+// and the decoder.
+func runSession(t *testing.T, rng *rand.Rand, p float64) (int, *Decoder[*testSymbol]) {
+	t.Helper()
+	enc := &Encoder[*testSymbol]{}
+	dec := &Decoder[*testSymbol]{}
+	enc.SetKey(testKeyA)
+	dec.SetKey(testKeyA)
+	for i := 0; i < 300; i++ {
+		s := newTestSymbol(uint64(i))
+		enc.AddSymbol(s)
+		if i%5 != 0 {
+			dec.AddSymbol(s)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		dec.AddSymbol(newTestSymbol(uint64(1000 + i)))
+	}
+	for i := 0; ; i++ {
+		if rng.Float64() < p {
+			edata, err := enc.Marshal(testSymbolCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ddata, err := dec.Marshal(testSymbolCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc = &Encoder[*testSymbol]{}
+			dec = &Decoder[*testSymbol]{}
+			enc.SetKey(testKeyA)
+			dec.SetKey(testKeyA)
+			if err := enc.Unmarshal(testSymbolCodec{}, edata); err != nil {
+				t.Fatal(err)
+			}
+			if err := dec.Unmarshal(testSymbolCodec{}, ddata); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i == 30 {
+			// leave a pending correction in the snapshots
+			c, err := enc.RemoveSymbol(newTestSymbol(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec.ApplyCorrection(c)
+		}
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		// sometimes save the decoder with dirty coded symbols
+		if rng.Intn(2) == 0 {
+			if err := dec.TryDecode(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i > 0 && dec.Decoded() {
+			return i+1, dec
+		}
+		if i > 10000 {
+			t.Fatal("failed to decode")
+		}
+	}
+}
+
+func TestSnapshotResume(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n, ref := runSession(t, rand.New(rand.NewSource(2)), 0)
+	for trial := 0; trial < 20; trial++ {
+		m, dec := runSession(t, rand.New(rand.NewSource(2)), rng.Float64()*0.2)
+		if m != n {
+			t.Fatalf("resumed session used %d coded symbols, uninterrupted one %d", m, n)
+		}
+		remote := make(map[uint64]struct{})
+		local := make(map[uint64]struct{})
+		for _, s := range ref.Remote() {
+			remote[s.Hash] = struct{}{}
+		}
+		for _, s := range ref.Local() {
+			local[s.Hash] = struct{}{}
+		}
+		checkDecoded(t, dec, remote, local)
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	for i := 0; i < 100; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for i := 0; i < 50; i++ {
+		enc.ProduceNextCodedSymbol()
+	}
+	data, _ := enc.Marshal(testSymbolCodec{})
+	// a different key does not replay the mappings
+	other := Encoder[*testSymbol]{}
+	other.SetKey(testKeyB)
+	if err := other.Unmarshal(testSymbolCodec{}, data); err != ErrMalformed {
+		t.Errorf("loading a snapshot with a different key returned %v", err)
+	}
+	for _, l := range []int{1, 5, len(data)/2, len(data)-1} {
+		if err := other.Unmarshal(testSymbolCodec{}, data[:l]); err != ErrMalformed {
+			t.Errorf("loading a snapshot truncated to %d bytes returned %v", l, err)
+		}
+	}
+	old := append([]byte{SnapshotVersion - 1}, data[1:]...)
+	if err := other.Unmarshal(testSymbolCodec{}, old); err != (VersionError{SnapshotVersion - 1}) {
+		t.Errorf("loading a snapshot of an old version returned %v", err)
+	}
+	dec := Decoder[*testSymbol]{}
+	if err := dec.Unmarshal(testSymbolCodec{}, old); err != (VersionError{SnapshotVersion - 1}) {
+		t.Errorf("loading a decoder snapshot of an old version returned %v", err)
+	}
+	// mappings that do not resume at the next coded symbol, or that are
+	// too far out to replay, are rejected
+	encoderSnapshot := func(nextIdx, lastIdx uint64) []byte {
+		buf := []byte{SnapshotVersion}
+		buf = binary.AppendUvarint(buf, nextIdx)
+		buf = binary.AppendUvarint(buf, 1)
+		buf = binary.LittleEndian.AppendUint64(buf, 1)
+		buf = binary.AppendUvarint(buf, lastIdx)
+		return testSymbolCodec{}.AppendSymbol(buf, newTestSymbol(1))
+	}
+	for _, c := range [][2]uint64{{50, 0}, {50, 49}, {50, 1 << 62}, {1 << 62, 1 << 62}, {math.MaxUint64, math.MaxUint64}} {
+		if err := other.Unmarshal(testSymbolCodec{}, encoderSnapshot(c[0], c[1])); err != ErrMalformed {
+			t.Errorf("loading a snapshot with next index %d and mapping at %d returned %v", c[0], c[1], err)
+		}
+	}
+	restored := Encoder[*testSymbol]{}
+	if err := restored.Unmarshal(testSymbolCodec{}, data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if !equalCodedSymbols(enc.ProduceNextCodedSymbol(), restored.ProduceNextCodedSymbol()) {
+			t.Fatalf("coded symbol %d differs after restoring", i)
+		}
+	}
+}
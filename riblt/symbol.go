@@ -1,5 +1,10 @@
 package riblt
 
+import (
+	"encoding/binary"
+	"github.com/dchest/siphash"
+)
+
 type Symbol[T any] interface {
 	// XOR returns the XOR result of the method receiver and t2. It is allowed
 	// to modify the method receiver during the operation. When the method
@@ -31,3 +36,33 @@ func (c CodedSymbol[T]) apply(s HashedSymbol[T], direction int64) CodedSymbol[T]
 	c.checksum ^= s.Hash
 	return c
 }
+
+const SaltSize = 16
+
+// Key is a per-session secret that rehashes symbol hashes before they are
+// used as checksums and as seeds of the mapping to coded symbols, so that a
+// peer that does not know the key cannot craft symbols that collide or
+// cluster onto the same coded symbols. Both sides of a session must use the
+// same key. The zero Key leaves hashes unchanged.
+type Key struct {
+	k0    uint64
+	k1    uint64
+	keyed bool
+}
+
+func NewKey(salt [SaltSize]byte) Key {
+	return Key{
+		k0:    binary.LittleEndian.Uint64(salt[0:8]),
+		k1:    binary.LittleEndian.Uint64(salt[8:16]),
+		keyed: true,
+	}
+}
+
+func (k Key) rehash(h uint64) uint64 {
+	if !k.keyed {
+		return h
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], h)
+	return siphash.Hash(k.k0, k.k1, b[:])
+}
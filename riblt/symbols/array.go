@@ -0,0 +1,29 @@
+package symbols
+
+import (
+	"unsafe"
+)
+
+// FixedSize is the set of byte array types that Array supports.
+type FixedSize interface {
+	~[8]byte | ~[16]byte | ~[20]byte | ~[24]byte | ~[32]byte | ~[48]byte | ~[64]byte | ~[128]byte | ~[256]byte
+}
+
+// Array is a symbol of a fixed-size byte array, e.g., Array[[32]byte]. It
+// is a value type, so XOR and Hash do not allocate.
+type Array[A FixedSize] struct {
+	Data A
+}
+
+func bytesOf[A FixedSize](a *A) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(a)), unsafe.Sizeof(*a))
+}
+
+func (a Array[A]) XOR(t2 Array[A]) Array[A] {
+	xorBytes(bytesOf(&a.Data), bytesOf(&t2.Data))
+	return a
+}
+
+func (a Array[A]) Hash() uint64 {
+	return hash(bytesOf(&a.Data))
+}
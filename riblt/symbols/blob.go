@@ -0,0 +1,74 @@
+package symbols
+
+import (
+	"encoding/binary"
+)
+
+const blobHeaderSize = 4
+
+// Blob is a symbol of a variable-length byte slice. It stores the length of
+// the data followed by the data, and the XOR of two blobs of different
+// lengths pads the shorter one with zeros, so that the XOR of a blob with
+// the XOR of itself and another one recovers the other one along with its
+// length. The zero Blob is the empty sum, not a blob of empty data; use
+// NewBlob(nil) for the latter.
+type Blob struct {
+	b []byte	// length as 4-byte little endian, data, and zero padding
+}
+
+// NewBlob creates a Blob of data. It copies data.
+func NewBlob(data []byte) Blob {
+	b := make([]byte, blobHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	copy(b[blobHeaderSize:], data)
+	return Blob{b}
+}
+
+// Bytes returns the data of the blob. It shares memory with the blob. When
+// the blob is a sum of several blobs, the result is meaningless. It returns
+// nil for the zero Blob.
+func (b Blob) Bytes() []byte {
+	if len(b.b) < blobHeaderSize {
+		return nil
+	}
+	return b.b[blobHeaderSize:b.end()]
+}
+
+// end returns the end of the data in b.b, without the padding.
+func (b Blob) end() int {
+	if len(b.b) < blobHeaderSize {
+		return len(b.b)
+	}
+	end := blobHeaderSize + int(binary.LittleEndian.Uint32(b.b))
+	if end > len(b.b) || end < blobHeaderSize {
+		// not a single blob
+		return len(b.b)
+	}
+	return end
+}
+
+// XOR returns the XOR of b and t2. It modifies b in place, growing it if
+// t2 is longer, and never shares memory with t2.
+func (b Blob) XOR(t2 Blob) Blob {
+	if len(t2.b) > len(b.b) {
+		if cap(b.b) >= len(t2.b) {
+			n := len(b.b)
+			b.b = b.b[:len(t2.b)]
+			for i := n; i < len(b.b); i++ {
+				b.b[i] = 0
+			}
+		} else {
+			nb := make([]byte, len(t2.b))
+			copy(nb, b.b)
+			b.b = nb
+		}
+	}
+	xorBytes(b.b, t2.b)
+	return b
+}
+
+// Hash returns the hash of the length and the data of b, so that padding
+// left by XOR does not change the hash.
+func (b Blob) Hash() uint64 {
+	return hash(b.b[:b.end()])
+}
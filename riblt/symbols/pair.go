@@ -0,0 +1,26 @@
+package symbols
+
+import (
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+)
+
+// Pair is a symbol of a key and a value, e.g., Pair[Array[[32]byte], Blob].
+// Its hash is that of the key only, so the value does not take part in the
+// checksums and the mapping of riblt. Two pairs with the same key and
+// different values cancel out in coded symbols except for the XOR of their
+// values, and are not reconciled; use Pair for sets where each key has one
+// value across all peers, such as content-addressed data.
+type Pair[K riblt.Symbol[K], V riblt.Symbol[V]] struct {
+	Key   K
+	Value V
+}
+
+func (p Pair[K, V]) XOR(t2 Pair[K, V]) Pair[K, V] {
+	p.Key = p.Key.XOR(t2.Key)
+	p.Value = p.Value.XOR(t2.Value)
+	return p
+}
+
+func (p Pair[K, V]) Hash() uint64 {
+	return p.Key.Hash()
+}
@@ -0,0 +1,26 @@
+// Package symbols provides implementations of riblt.Symbol for common kinds
+// of data: fixed-size byte arrays, variable-length byte blobs, and key/value
+// pairs.
+package symbols
+
+import (
+	"github.com/dchest/siphash"
+)
+
+// keys of the hash of symbols; they need not be secret, as riblt rehashes
+// symbol hashes with a per-session key when asked to
+const (
+	hashKey0 uint64 = 0x736f6d6570736575
+	hashKey1 uint64 = 0x646f72616e646f6d
+)
+
+func hash(b []byte) uint64 {
+	return siphash.Hash(hashKey0, hashKey1, b)
+}
+
+// xorBytes sets dst to the XOR of dst and src, which must not be longer.
+func xorBytes(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,132 @@
+package symbols
+
+import (
+	"bytes"
+	"testing"
+	"github.com/yangl1996/rateless-set-reconcile/riblt"
+)
+
+func newArray(data []byte) Array[[32]byte] {
+	a := Array[[32]byte]{}
+	copy(a.Data[:], data)
+	return a
+}
+
+func FuzzArray(f *testing.F) {
+	f.Add([]byte("hello"), []byte("world"))
+	f.Add([]byte{}, bytes.Repeat([]byte{0xff}, 40))
+	f.Fuzz(func(t *testing.T, d1, d2 []byte) {
+		a, b := newArray(d1), newArray(d2)
+		orig := a
+		h := a.Hash()
+		if a != orig {
+			t.Fatal("Hash changed its receiver")
+		}
+		if a.XOR(b).XOR(b) != orig {
+			t.Fatal("XOR is not an involution")
+		}
+		if (Array[[32]byte]{}).XOR(a) != a {
+			t.Fatal("XOR of the zero array is not the identity")
+		}
+		if a.XOR(b).XOR(b).Hash() != h {
+			t.Fatal("hash changed after XOR twice")
+		}
+	})
+}
+
+func FuzzBlob(f *testing.F) {
+	f.Add([]byte("hello"), []byte("world!"))
+	f.Add([]byte{}, []byte{0})
+	f.Add(bytes.Repeat([]byte{0xff}, 100), []byte{})
+	f.Fuzz(func(t *testing.T, d1, d2 []byte) {
+		a, b := NewBlob(d1), NewBlob(d2)
+		h := a.Hash()
+		if !bytes.Equal(a.Bytes(), d1) {
+			t.Fatal("Hash changed its receiver")
+		}
+		if (Blob{}).Bytes() != nil {
+			t.Fatal("the zero blob has data")
+		}
+		s := Blob{}.XOR(a)
+		if !bytes.Equal(s.Bytes(), d1) || s.Hash() != h {
+			t.Fatal("XOR of the zero blob is not the identity")
+		}
+		s = s.XOR(b).XOR(b)
+		if !bytes.Equal(s.Bytes(), d1) {
+			t.Fatal("XOR is not an involution")
+		}
+		if s.Hash() != h {
+			t.Fatal("padding left by XOR changed the hash")
+		}
+		// a and b are not modified by being the argument of XOR
+		if !bytes.Equal(a.Bytes(), d1) || !bytes.Equal(b.Bytes(), d2) {
+			t.Fatal("XOR changed its argument")
+		}
+	})
+}
+
+func FuzzPair(f *testing.F) {
+	f.Add([]byte("key"), []byte("value"), []byte("other value"))
+	f.Fuzz(func(t *testing.T, k, v1, v2 []byte) {
+		p := Pair[Array[[32]byte], Blob]{newArray(k), NewBlob(v1)}
+		q := Pair[Array[[32]byte], Blob]{newArray(k), NewBlob(v2)}
+		h := p.Hash()
+		if h != p.Key.Hash() || h != q.Hash() {
+			t.Fatal("hash does not cover only the key")
+		}
+		if !bytes.Equal(p.Value.Bytes(), v1) {
+			t.Fatal("Hash changed its receiver")
+		}
+		s := Pair[Array[[32]byte], Blob]{}.XOR(p).XOR(q).XOR(q)
+		if s.Key != p.Key || !bytes.Equal(s.Value.Bytes(), v1) {
+			t.Fatal("XOR is not an involution")
+		}
+	})
+}
+
+func TestReconcileBlobs(t *testing.T) {
+	enc := riblt.Encoder[Blob]{}
+	dec := riblt.Decoder[Blob]{}
+	remote := make(map[string]struct{})
+	local := make(map[string]struct{})
+	for i := 0; i < 500; i++ {
+		data := []byte{byte(i), byte(i>>8)}
+		data = append(data, bytes.Repeat([]byte{0xaa}, i%37)...)
+		switch i % 10 {
+		case 0:
+			enc.AddSymbol(NewBlob(data))
+			remote[string(data)] = struct{}{}
+		case 1:
+			dec.AddSymbol(NewBlob(data))
+			local[string(data)] = struct{}{}
+		default:
+			enc.AddSymbol(NewBlob(data))
+			dec.AddSymbol(NewBlob(data))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		if err := dec.TryDecode(); err != nil {
+			t.Fatal(err)
+		}
+		if dec.Decoded() {
+			break
+		}
+	}
+	if !dec.Decoded() {
+		t.Fatal("failed to decode")
+	}
+	if len(dec.Remote()) != len(remote) || len(dec.Local()) != len(local) {
+		t.Fatalf("decoded %d remote and %d local blobs, expected %d and %d", len(dec.Remote()), len(dec.Local()), len(remote), len(local))
+	}
+	for _, s := range dec.Remote() {
+		if _, there := remote[string(s.Symbol.Bytes())]; !there {
+			t.Fatal("incorrect remote blob decoded")
+		}
+	}
+	for _, s := range dec.Local() {
+		if _, there := local[string(s.Symbol.Bytes())]; !there {
+			t.Fatal("incorrect local blob decoded")
+		}
+	}
+}
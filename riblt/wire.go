@@ -0,0 +1,236 @@
+package riblt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WireVersion is the version of the binary format of coded symbols. It is
+// the first byte of every marshaled coded symbol or sketch, and follows the
+// magic bytes at the beginning of a stream.
+const WireVersion = 1
+
+var streamMagic = [4]byte{'R', 'B', 'L', 'T'}
+
+// ByteReader is the interface that SymbolCodec reads symbols from.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// SymbolCodec serializes symbols for the binary format of coded symbols. The
+// symbol passed to AppendSymbol may be the default value of T, which is the
+// sum of an empty coded symbol.
+type SymbolCodec[T any] interface {
+	// AppendSymbol appends the binary form of t to buf and returns the
+	// extended buffer.
+	AppendSymbol(buf []byte, t T) []byte
+	// ReadSymbol reads one symbol written by AppendSymbol from r.
+	ReadSymbol(r ByteReader) (T, error)
+}
+
+// VersionError is returned when decoding data of an unknown wire or snapshot
+// version.
+type VersionError struct {
+	Version byte
+}
+
+func (e VersionError) Error() string {
+	return "unsupported wire version"
+}
+
+var (
+	ErrMalformed = errors.New("malformed coded symbol data")
+	ErrBadMagic  = errors.New("data does not start with the expected magic bytes")
+)
+
+// The wire form of a coded symbol is the count as a zig-zag varint, the
+// checksum as a uvarint, and the sum as written by the symbol codec. Coded
+// symbols deep in a stream or a sketch have small counts, so the count
+// usually takes one byte.
+func (c CodedSymbol[T]) appendBinary(codec SymbolCodec[T], buf []byte) []byte {
+	buf = binary.AppendVarint(buf, c.count)
+	buf = binary.AppendUvarint(buf, c.checksum)
+	return codec.AppendSymbol(buf, c.sum)
+}
+
+func (c *CodedSymbol[T]) readBinary(codec SymbolCodec[T], r ByteReader) error {
+	count, err := binary.ReadVarint(r)
+	if err != nil {
+		return err
+	}
+	checksum, err := binary.ReadUvarint(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	sum, err := codec.ReadSymbol(r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	c.sum = sum
+	c.count = count
+	c.checksum = checksum
+	return nil
+}
+
+// Marshal encodes c using codec to serialize its sum. Marshal and Unmarshal
+// take the codec, so they are not named after encoding.BinaryMarshaler.
+func (c CodedSymbol[T]) Marshal(codec SymbolCodec[T]) ([]byte, error) {
+	buf := []byte{WireVersion}
+	return c.appendBinary(codec, buf), nil
+}
+
+// Unmarshal decodes data produced by Marshal into c.
+func (c *CodedSymbol[T]) Unmarshal(codec SymbolCodec[T], data []byte) error {
+	if len(data) == 0 {
+		return ErrMalformed
+	}
+	if data[0] != WireVersion {
+		return VersionError{data[0]}
+	}
+	r := bytes.NewReader(data[1:])
+	if err := c.readBinary(codec, r); err != nil {
+		return malformed(err)
+	}
+	if r.Len() != 0 {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Marshal encodes the sketch using codec to serialize the sums of its
+// coded symbols.
+func (s Sketch[T]) Marshal(codec SymbolCodec[T]) ([]byte, error) {
+	buf := []byte{WireVersion}
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	for _, c := range s {
+		buf = c.appendBinary(codec, buf)
+	}
+	return buf, nil
+}
+
+// Unmarshal decodes data produced by Sketch.Marshal into s, replacing its
+// content.
+func (s *Sketch[T]) Unmarshal(codec SymbolCodec[T], data []byte) error {
+	if len(data) == 0 {
+		return ErrMalformed
+	}
+	if data[0] != WireVersion {
+		return VersionError{data[0]}
+	}
+	r := bytes.NewReader(data[1:])
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return malformed(err)
+	}
+	// every coded symbol takes at least two bytes, so a larger length
+	// cannot be genuine and we should not allocate for it
+	if n > uint64(r.Len()) {
+		return ErrMalformed
+	}
+	res := make(Sketch[T], n)
+	for i := range res {
+		if err := res[i].readBinary(codec, r); err != nil {
+			return malformed(err)
+		}
+	}
+	if r.Len() != 0 {
+		return ErrMalformed
+	}
+	*s = res
+	return nil
+}
+
+// Writer writes a stream of coded symbols to an io.Writer. The stream starts
+// with magic bytes and the wire version, which are written along with the
+// first coded symbol.
+type Writer[T Symbol[T]] struct {
+	w             io.Writer
+	codec         SymbolCodec[T]
+	buf           []byte
+	headerWritten bool
+}
+
+func NewWriter[T Symbol[T]](w io.Writer, codec SymbolCodec[T]) *Writer[T] {
+	return &Writer[T]{
+		w:     w,
+		codec: codec,
+	}
+}
+
+// WriteCodedSymbol writes c to the stream with a single call to Write on the
+// underlying writer.
+func (w *Writer[T]) WriteCodedSymbol(c CodedSymbol[T]) error {
+	w.buf = w.buf[:0]
+	if !w.headerWritten {
+		w.buf = append(w.buf, streamMagic[:]...)
+		w.buf = append(w.buf, WireVersion)
+	}
+	w.buf = c.appendBinary(w.codec, w.buf)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.headerWritten = true
+	return nil
+}
+
+// Reader reads a stream of coded symbols written by Writer.
+type Reader[T Symbol[T]] struct {
+	r          ByteReader
+	codec      SymbolCodec[T]
+	headerRead bool
+}
+
+// NewReader creates a Reader that reads from r. If r does not implement
+// ByteReader, it is wrapped in a bufio.Reader, which may read past the end of
+// the stream.
+func NewReader[T Symbol[T]](r io.Reader, codec SymbolCodec[T]) *Reader[T] {
+	br, ok := r.(ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader[T]{
+		r:     br,
+		codec: codec,
+	}
+}
+
+// ReadCodedSymbol reads the next coded symbol. It returns io.EOF if the
+// stream ends cleanly between two coded symbols.
+func (r *Reader[T]) ReadCodedSymbol() (CodedSymbol[T], error) {
+	c := CodedSymbol[T]{}
+	if !r.headerRead {
+		var header [len(streamMagic) + 1]byte
+		if _, err := io.ReadFull(r.r, header[:]); err != nil {
+			return c, err
+		}
+		if !bytes.Equal(header[:len(streamMagic)], streamMagic[:]) {
+			return c, ErrBadMagic
+		}
+		if v := header[len(streamMagic)]; v != WireVersion {
+			return c, VersionError{v}
+		}
+		r.headerRead = true
+	}
+	err := c.readBinary(r.codec, r.r)
+	return c, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// malformed converts errors from reading an in-memory buffer, where running
+// out of data means the buffer is truncated, to ErrMalformed.
+func malformed(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrMalformed
+	}
+	return err
+}
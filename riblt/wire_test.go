@@ -0,0 +1,174 @@
+package riblt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type testSymbolCodec struct{}
+
+func (testSymbolCodec) AppendSymbol(buf []byte, t *testSymbol) []byte {
+	if t == nil {
+		t = &testSymbol{}
+	}
+	return append(buf, t[:]...)
+}
+
+func (testSymbolCodec) ReadSymbol(r ByteReader) (*testSymbol, error) {
+	t := &testSymbol{}
+	_, err := io.ReadFull(r, t[:])
+	return t, err
+}
+
+func equalCodedSymbols(a, b CodedSymbol[*testSymbol]) bool {
+	if a.count != b.count || a.checksum != b.checksum {
+		return false
+	}
+	as := a.sum
+	if as == nil {
+		as = &testSymbol{}
+	}
+	bs := b.sum
+	if bs == nil {
+		bs = &testSymbol{}
+	}
+	return *as == *bs
+}
+
+func TestMarshalCodedSymbol(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	for i := 0; i < 100; i++ {
+		enc.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	for i := 0; i < 200; i++ {
+		c := enc.ProduceNextCodedSymbol()
+		data, err := c.Marshal(testSymbolCodec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// version byte, at most 10 bytes of checksum and a few bytes of count
+		if len(data) > 1+testSymbolSize+10+2 {
+			t.Errorf("coded symbol %d takes %d bytes", i, len(data))
+		}
+		d := CodedSymbol[*testSymbol]{}
+		if err := d.Unmarshal(testSymbolCodec{}, data); err != nil {
+			t.Fatal(err)
+		}
+		if !equalCodedSymbols(c, d) {
+			t.Fatalf("coded symbol %d corrupted during marshalling", i)
+		}
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	c := CodedSymbol[*testSymbol]{}
+	c = c.apply(HashedSymbol[*testSymbol]{newTestSymbol(1), newTestSymbol(1).Hash()}, add)
+	data, _ := c.Marshal(testSymbolCodec{})
+
+	d := CodedSymbol[*testSymbol]{}
+	if err := d.Unmarshal(testSymbolCodec{}, nil); err != ErrMalformed {
+		t.Error("failed to report empty data")
+	}
+	if err := d.Unmarshal(testSymbolCodec{}, data[:len(data)-1]); err != ErrMalformed {
+		t.Error("failed to report truncated data")
+	}
+	if err := d.Unmarshal(testSymbolCodec{}, append(data, 0)); err != ErrMalformed {
+		t.Error("failed to report trailing data")
+	}
+	data[0] = WireVersion + 1
+	if _, ok := d.Unmarshal(testSymbolCodec{}, data).(VersionError); !ok {
+		t.Error("failed to report unknown version")
+	}
+}
+
+func TestMarshalSketch(t *testing.T) {
+	s := make(Sketch[*testSymbol], 50)
+	for i := 0; i < 30; i++ {
+		s.AddSymbol(newTestSymbol(uint64(i)))
+	}
+	data, err := s.Marshal(testSymbolCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s2 Sketch[*testSymbol]
+	if err := s2.Unmarshal(testSymbolCodec{}, data); err != nil {
+		t.Fatal(err)
+	}
+	if len(s2) != len(s) {
+		t.Fatalf("sketch has %d coded symbols after unmarshalling, expected %d", len(s2), len(s))
+	}
+	for i := range s {
+		if !equalCodedSymbols(s[i], s2[i]) {
+			t.Errorf("coded symbol %d corrupted during marshalling", i)
+		}
+	}
+	if err := s2.Unmarshal(testSymbolCodec{}, data[:len(data)-1]); err != ErrMalformed {
+		t.Error("failed to report truncated sketch")
+	}
+}
+
+func TestStreamCodedSymbols(t *testing.T) {
+	enc := Encoder[*testSymbol]{}
+	dec := Decoder[*testSymbol]{}
+	var nextId uint64
+	for i := 0; i < 100; i++ {
+		dec.AddSymbol(newTestSymbol(nextId))
+		nextId += 1
+	}
+	for i := 0; i < 100; i++ {
+		enc.AddSymbol(newTestSymbol(nextId))
+		nextId += 1
+	}
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(nextId)
+		nextId += 1
+		enc.AddSymbol(s)
+		dec.AddSymbol(s)
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewWriter[*testSymbol](buf, testSymbolCodec{})
+	ncw := 1000
+	for i := 0; i < ncw; i++ {
+		if err := w.WriteCodedSymbol(enc.ProduceNextCodedSymbol()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Logf("%.2f bytes per coded symbol of %d-byte symbols", float64(buf.Len())/float64(ncw), testSymbolSize)
+
+	r := NewReader[*testSymbol](buf, testSymbolCodec{})
+	nread := 0
+	for {
+		c, err := r.ReadCodedSymbol()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		nread += 1
+		dec.AddCodedSymbol(c)
+	}
+	if nread != ncw {
+		t.Errorf("read %d coded symbols, expected %d", nread, ncw)
+	}
+	dec.TryDecode()
+	if !dec.Decoded() {
+		t.Error("failed to decode coded symbols read from stream")
+	}
+	if len(dec.Remote()) != 100 || len(dec.Local()) != 100 {
+		t.Errorf("decoded %d remote and %d local symbols, expected 100 each", len(dec.Remote()), len(dec.Local()))
+	}
+}
+
+func TestStreamBadHeader(t *testing.T) {
+	r := NewReader[*testSymbol](bytes.NewReader([]byte("GARBAGE DATA")), testSymbolCodec{})
+	if _, err := r.ReadCodedSymbol(); err != ErrBadMagic {
+		t.Error("failed to report bad magic bytes")
+	}
+	data := append(streamMagic[:], WireVersion+1)
+	r = NewReader[*testSymbol](bytes.NewReader(data), testSymbolCodec{})
+	if _, err := r.ReadCodedSymbol(); err != (VersionError{WireVersion + 1}) {
+		t.Error("failed to report unknown stream version")
+	}
+}
@@ -27,6 +27,7 @@ func main() {
 	synchronizationPeriod := flag.Duration("sync", 0, "synchronize block generation with given period")
 	targetCodewordLoss := flag.Float64("l", 0.0, "target codeword loss rate for controller")
 	topologyFile := flag.String("topo", "", "topology file")
+	seeded := flag.Bool("seeded", false, "derive codeword members from a seed instead of listing them")
 	flag.Parse()
 
 	config := serverConfig {
@@ -39,6 +40,7 @@ func main() {
 		senderConfig: senderConfig{
 			detectThreshold: *detectThreshold,
 			controlOverhead: *controlOverhead,
+			seeded: *seeded,
 		},
 		receiverConfig: receiverConfig{
 			detectThreshold: *detectThreshold,
@@ -85,6 +87,15 @@ func main() {
 	fmt.Println("# overhead", collectMoments(servers, func(s *server) float64 {
 		return float64(s.receivedCodewords) / float64(s.decodedTransactions)
 	}))
+	fmt.Println("# membership bytes per codeword", collectMoments(servers, func(s *server) float64 {
+		return float64(s.membershipBytes) / float64(s.receivedCodewords)
+	}))
+	fmt.Println("# membership bytes per codeword if listed", collectMoments(servers, func(s *server) float64 {
+		return float64(s.listedMembershipBytes) / float64(s.receivedCodewords)
+	}))
+	fmt.Println("# window mismatches", collectMoments(servers, func(s *server) float64 {
+		return float64(s.windowMismatches)
+	}))
 	fmt.Println("# latency p5", collectMoments(servers, func(s *server) float64 {
 		return s.latencySketch.getQuantiles([]float64{0.05})[0]
 	}))
@@ -6,9 +6,20 @@ import (
 
 type codeword struct {
 	lt.Codeword[transaction]
+	seeded *lt.SeededCodeword[transaction] // sent instead when members are derived from a seed
 	newBlock bool
 }
 
+// membershipSize returns the number of bytes the codeword takes to tell its
+// members, and the number it would take if they were listed.
+func (c codeword) membershipSize() (int, int) {
+	if c.seeded != nil {
+		return c.seeded.MembershipSize(), c.seeded.ListedMembershipSize()
+	}
+	size := c.Codeword.MembershipSize()
+	return size, size
+}
+
 type ack struct {
 	ackBlock bool
 }
@@ -10,11 +10,17 @@ import (
 type serverMetric struct {
 	decodedTransactions int
 	receivedCodewords    int
+	membershipBytes      int // spent by received codewords to tell their members
+	listedMembershipBytes int // that they would spend if the members were listed
+	windowMismatches     int // seeded codewords with members not in the window mirror
 }
 
 func (m *serverMetric) resetMetric() {
 	m.decodedTransactions = 0
 	m.receivedCodewords = 0
+	m.membershipBytes = 0
+	m.listedMembershipBytes = 0
+	m.windowMismatches = 0
 }
 
 type serverConfig struct {
@@ -70,6 +76,7 @@ func (a *server) newHandler() *handler {
 		},
 		receiver: &receiver{
 			Decoder: a.decoder,
+			mirror: lt.NewWindowMirror(a.decoderMemory),
 			receiverConfig: a.receiverConfig,
 		},
 	}
@@ -174,7 +181,13 @@ func (s *server) HandleMessage(payload any, from des.Module, timestamp time.Dura
 		n := s.handlers[from]
 		switch m := payload.(type) {
 		case codeword:
-			buf := n.onCodeword(m)
+			buf, err := n.onCodeword(m)
+			if err != nil {
+				s.windowMismatches += 1
+			}
+			size, listedSize := m.membershipSize()
+			s.membershipBytes += size
+			s.listedMembershipBytes += listedSize
 			for _, val := range buf {
 				s.registerReceived(val)
 				s.latencySketch.recordTxLatency(val.Data(), timestamp)
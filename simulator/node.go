@@ -12,6 +12,7 @@ var testKey = [lt.SaltSize]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
 type senderConfig struct {
 	controlOverhead float64
 	detectThreshold int
+	seeded bool
 }
 
 type sender struct {
@@ -91,7 +92,12 @@ func (n *sender) tryProduceCodeword(mayStartNewBlock bool) (codeword, bool) {
 	} else if (!n.encodingCurrentBlock) && (!mayStartNewBlock) {
 		return cw, false
 	}
-	cw.Codeword = n.Encoder.ProduceCodeword()
+	if n.seeded {
+		seeded := n.Encoder.ProduceSeededCodeword()
+		cw.seeded = &seeded
+	} else {
+		cw.Codeword = n.Encoder.ProduceCodeword()
+	}
 	return cw, true
 }
 
@@ -102,6 +108,7 @@ type receiverConfig struct {
 
 type receiver struct {
 	*lt.Decoder[transaction]
+	mirror *lt.WindowMirror
 	curCodewords []*lt.PendingCodeword[transaction]
 
 	currentBlockReceived bool
@@ -112,12 +119,24 @@ type receiver struct {
 	receiverConfig
 }
 
-func (n *receiver) onCodeword(cw codeword) []lt.Transaction[transaction] {
+func (n *receiver) onCodeword(cw codeword) ([]lt.Transaction[transaction], error) {
 	if cw.newBlock {
 		n.curCodewords = n.curCodewords[:0]
 		n.currentBlockReceived = false
 	}
-	stub, tx := n.Decoder.AddCodeword(cw.Codeword)
+	var stub *lt.PendingCodeword[transaction]
+	var tx []lt.Transaction[transaction]
+	if cw.seeded != nil {
+		var err error
+		stub, tx, err = n.Decoder.AddSeededCodeword(n.mirror, *cw.seeded)
+		if err != nil {
+			// the codeword is useless, but it still takes up the window
+			n.outbox = append(n.outbox, ack{false})
+			return nil, err
+		}
+	} else {
+		stub, tx = n.Decoder.AddCodeword(cw.Codeword)
+	}
 	n.curCodewords = append(n.curCodewords, stub)
 
 	if !n.currentBlockReceived && len(n.curCodewords) > n.detectThreshold {
@@ -132,10 +151,10 @@ func (n *receiver) onCodeword(cw codeword) []lt.Transaction[transaction] {
 		if notDecoded <= limit {
 			n.currentBlockReceived = true
 			n.outbox = append(n.outbox, ack{true})
-			return tx
+			return tx, nil
 		}
 	}
 	n.outbox = append(n.outbox, ack{false})
-	return tx
+	return tx, nil
 }
 
@@ -2,10 +2,15 @@ package main
 
 import (
 	"strconv"
+	"context"
 	"flag"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"sync"
 	"os/exec"
 	"strings"
@@ -13,6 +18,10 @@ import (
 	"time"
 )
 
+// metricsAddr is where the nodes serve their metrics. It is only reachable
+// on the server, so the testbed polls it through the ssh connection.
+const metricsAddr = "127.0.0.1:9100"
+
 type RemoteError struct {
 	inner   error
 	problem string
@@ -35,6 +44,9 @@ func dispatchBwTest(args []string) {
 	runExp := command.String("run", "", "run the test with the given setup file")
 	downloadResults := command.String("dl", "", "download the results and store it with the given prefix")
 	measure := command.String("ping", "", "ping the nodes to get the latency using the given setup file")
+	pollMetrics := command.String("poll", "", "poll the metrics of the nodes and store them with the given prefix")
+	pollInterval := command.Duration("pollintv", time.Second, "interval between polls of the metrics")
+	pollDuration := command.Duration("polldur", 0, "duration to poll the metrics for; until interrupted if 0")
 
 	command.Parse(args[0:])
 
@@ -102,7 +114,8 @@ func dispatchBwTest(args []string) {
 	}
 
 	if *runExp != "" {
-		// port scanners send garbage data and confuses gob; randomize the port to mitigate
+		// port scanners send garbage data; the node drops such connections
+		// when the handshake fails, but randomize the port to keep them rare
 
 		port := int(rand.Float64() * 40000.0) + 10000
 		exp := ReadExperimentInfo(*runExp)
@@ -132,13 +145,26 @@ func dispatchBwTest(args []string) {
 			} else {
 				cmd += fmt.Sprintf("./txcode-node")
 			}
-			cmd += fmt.Sprintf(" -l 0.0.0.0:%d %s > log.txt 2>&1 &'", port, strings.Join(command.Args(), " "))
+			cmd += fmt.Sprintf(" -l 0.0.0.0:%d -metrics %s %s > log.txt 2>&1 &'", port, metricsAddr, strings.Join(command.Args(), " "))
 			fmt.Println(s.Location, "started running")
 			return sess.Run(cmd)
 		}
 		runAll(servers, clients, fn)
 	}
 
+	if *pollMetrics != "" {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		if *pollDuration != 0 {
+			ctx, cancel = context.WithTimeout(ctx, *pollDuration)
+			defer cancel()
+		}
+		fn := func(i int, s Server, c *ssh.Client) error {
+			return pollServerMetrics(ctx, c, *pollInterval, fmt.Sprintf("%s-%d.prom", *pollMetrics, i))
+		}
+		runAll(servers, clients, fn)
+	}
+
 	if *downloadResults != "" {
 		fn := func(i int, s Server, c *ssh.Client) error {
 			if err := killServer(c); err != nil {
@@ -151,6 +177,47 @@ func dispatchBwTest(args []string) {
 }
 
 
+// pollServerMetrics scrapes the metrics of the node behind c every interval
+// until ctx is done, and appends each scrape to the file at dest after a line
+// with the time of the scrape.
+func pollServerMetrics(ctx context.Context, c *ssh.Client, interval time.Duration, dest string) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return c.Dial(network, addr)
+			},
+		},
+		Timeout: interval,
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		at := time.Now()
+		resp, err := client.Get("http://" + metricsAddr + "/metrics")
+		if err != nil {
+			// the node may not be up yet, or may be restarting
+			fmt.Printf("error polling metrics at %v: %v\n", dest, err)
+		} else {
+			fmt.Fprintf(f, "# scrape at %d\n", at.UnixMilli())
+			_, err = io.Copy(f, resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 func runAll(servers []Server, clients []*ssh.Client, fn func(int, Server, *ssh.Client) error) error {
 	if len(servers) != len(clients) {
 		panic("incorrect")